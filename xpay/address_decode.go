@@ -1,7 +1,12 @@
 package xpay
 
 import (
+	"crypto/elliptic"
 	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strings"
+
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 )
@@ -25,7 +30,7 @@ func (dec *AddressDecoderV2) AddressDecode(addr string, opts ...interface{}) ([]
 	return hex.DecodeString(addr)
 }
 
-//AddressEncode 地址编码
+//AddressEncode 地址编码，不访问网络，地址加入登记队列由后台向钱包服务登记
 func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (string, error) {
 	if len(hash) >= 64 {
 		//压缩公钥
@@ -33,9 +38,11 @@ func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (st
 	}
 
 	address := hex.EncodeToString(hash)
-	err := dec.wm.InformWallet(address, dec.wm.Symbol())
-	if err != nil {
-		return "", err
+
+	if dec.wm != nil {
+		if err := dec.wm.EnqueueInform(address, dec.wm.Symbol()); err != nil {
+			dec.wm.Log.Errorf("enqueue inform address %s failed, err: %v", address, err)
+		}
 	}
 
 	return address, nil
@@ -43,13 +50,39 @@ func (dec *AddressDecoderV2) AddressEncode(hash []byte, opts ...interface{}) (st
 
 // AddressVerify 地址校验
 func (dec *AddressDecoderV2) AddressVerify(address string, opts ...interface{}) bool {
+	_, err := ValidateAddress(address)
+	return err == nil
+}
+
+//ValidateAddress 校验地址并返回小写格式，地址为P-256的压缩公钥，前缀为02或03，且能解压为曲线上的点
+func ValidateAddress(address string) (string, error) {
+	address = strings.ToLower(address)
 	pub, err := hex.DecodeString(address)
 	if err != nil {
-		return false
+		return "", fmt.Errorf("address [%s] is not hex", address)
 	}
-
 	if len(pub) != 33 {
+		return "", fmt.Errorf("address [%s] length is %d bytes, want 33", address, len(pub))
+	}
+	if pub[0] != 0x02 && pub[0] != 0x03 {
+		return "", fmt.Errorf("address [%s] prefix is %02x, want 02 or 03", address, pub[0])
+	}
+	if !isOnCurve(pub) {
+		return "", fmt.Errorf("address [%s] is not a point on curve P-256", address)
+	}
+	return address, nil
+}
+
+//isOnCurve 压缩公钥解压后是否为P-256曲线上的点，x需要小于素数域
+func isOnCurve(pub []byte) bool {
+	curve := elliptic.P256()
+	x := new(big.Int).SetBytes(pub[1:])
+	if x.Cmp(curve.Params().P) >= 0 {
+		return false
+	}
+	point := owcrypt.PointDecompress(pub, CurveType)
+	if len(point) != 65 {
 		return false
 	}
-	return true
+	return curve.IsOnCurve(x, new(big.Int).SetBytes(point[33:]))
 }
@@ -2,8 +2,10 @@ package xpay
 
 import (
 	"encoding/hex"
-	"github.com/blocktree/go-owcrypt"
+	"strings"
 	"testing"
+
+	"github.com/blocktree/go-owcrypt"
 )
 
 func TestAddressDecoder_AddressEncode(t *testing.T) {
@@ -17,4 +19,32 @@ func TestDecompressPubKey(t *testing.T) {
 	pub, _ := hex.DecodeString("03a2147994c34ec6ac3ba4d0737e672002a832f2cf050f0d2cffc7906ad8a1e7b2")
 	uncompessedPublicKey := owcrypt.PointDecompress(pub, owcrypt.ECC_CURVE_SECP256K1)
 	t.Logf("pub: %s", hex.EncodeToString(uncompessedPublicKey))
-}
\ No newline at end of file
+}
+
+func TestValidateAddress(t *testing.T) {
+	address := testAddress("valid")
+	got, err := ValidateAddress(strings.ToUpper(address))
+	if err != nil || got != address {
+		t.Fatalf("ValidateAddress = %s, %v, want %s", got, err, address)
+	}
+	if !NewAddressDecoderV2(nil).AddressVerify(address) {
+		t.Errorf("AddressVerify(%s) = false", address)
+	}
+
+	//x坐标不在曲线上
+	notOnCurve := "02" + strings.Repeat("00", 31) + "01"
+	invalid := []string{
+		"",
+		"zz" + address[2:],
+		address[:64],
+		"04" + address[2:],
+		"05" + address[2:],
+		notOnCurve,
+		"02" + strings.Repeat("ff", 32),
+	}
+	for _, addr := range invalid {
+		if _, err := ValidateAddress(addr); err == nil {
+			t.Errorf("ValidateAddress(%s) should fail", addr)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/shopspring/decimal"
+)
+
+//AmountDecimals XIF数量的小数位数
+const AmountDecimals = 8
+
+var amountPattern = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?$`)
+
+//Amount XIF数量，非负且最多8位小数
+//String输出规范格式：去掉末尾的0，没有指数和正号，例如0.50规范为0.5，签名消息和广播都使用该格式
+type Amount struct {
+	d decimal.Decimal
+}
+
+//ParseAmount 严格解析数量，只接受十进制数字，拒绝负数、指数和超过8位的小数
+func ParseAmount(s string) (Amount, error) {
+	if !amountPattern.MatchString(s) {
+		return Amount{}, fmt.Errorf("malformed amount: %q", s)
+	}
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return Amount{}, fmt.Errorf("malformed amount: %q", s)
+	}
+	return NewAmount(d)
+}
+
+//ParseTransferAmount 解析转账数量，数量必须大于0
+func ParseTransferAmount(s string) (Amount, error) {
+	a, err := ParseAmount(s)
+	if err != nil {
+		return Amount{}, err
+	}
+	if !a.IsPositive() {
+		return Amount{}, fmt.Errorf("amount must be greater than 0: %q", s)
+	}
+	return a, nil
+}
+
+//NewAmount 从decimal创建数量，拒绝负数和超过8位的小数
+func NewAmount(d decimal.Decimal) (Amount, error) {
+	if d.Sign() < 0 {
+		return Amount{}, fmt.Errorf("amount is negative: %s", d.String())
+	}
+	if !d.Equal(d.Truncate(AmountDecimals)) {
+		return Amount{}, fmt.Errorf("amount %s has more than %d decimals", d.String(), AmountDecimals)
+	}
+	return Amount{d: d}, nil
+}
+
+//TruncateAmount 截断到8位小数，用于计算结果（例如汇总数量），不会超出实际余额
+func TruncateAmount(d decimal.Decimal) Amount {
+	if d.Sign() < 0 {
+		return Amount{}
+	}
+	return Amount{d: d.Truncate(AmountDecimals)}
+}
+
+//Decimal 数量的decimal值，用于计算
+func (a Amount) Decimal() decimal.Decimal {
+	return a.d
+}
+
+//IsPositive 数量是否大于0
+func (a Amount) IsPositive() bool {
+	return a.d.Sign() > 0
+}
+
+//String 规范格式
+func (a Amount) String() string {
+	return a.d.String()
+}
+
+//CanonicalAmount 数量字符串的规范格式，无法解析时原样返回，由节点拒绝
+func CanonicalAmount(s string) string {
+	a, err := ParseAmount(s)
+	if err != nil {
+		return s
+	}
+	return a.String()
+}
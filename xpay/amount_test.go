@@ -0,0 +1,82 @@
+package xpay
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestParseAmount(t *testing.T) {
+	valid := map[string]string{
+		"0.5":        "0.5",
+		"0.50":       "0.5",
+		"1":          "1",
+		"1.00000000": "1",
+		"0.00000001": "0.00000001",
+		"0100":       "100",
+	}
+	for s, want := range valid {
+		a, err := ParseTransferAmount(s)
+		if err != nil {
+			t.Errorf("ParseTransferAmount(%q) failed, err: %v", s, err)
+			continue
+		}
+		if a.String() != want {
+			t.Errorf("ParseTransferAmount(%q) = %s, want %s", s, a.String(), want)
+		}
+	}
+
+	invalid := []string{"", "0", "0.0", "-1", "+1", "1e3", ".5", "1.", " 1", "1,5", "0.000000001", "abc"}
+	for _, s := range invalid {
+		if _, err := ParseTransferAmount(s); err == nil {
+			t.Errorf("ParseTransferAmount(%q) should fail", s)
+		}
+	}
+
+	//多余的0不影响精度
+	if _, err := ParseAmount("0.100000000"); err != nil {
+		t.Errorf("ParseAmount failed, err: %v", err)
+	}
+}
+
+func TestRawTransaction_HashCanonicalAmount(t *testing.T) {
+	a := &RawTransaction{Sender: "s", Recipient: "r", Symbol: Symbol, Amount: "0.5", Nonce: 1}
+	b := &RawTransaction{Sender: "s", Recipient: "r", Symbol: Symbol, Amount: "0.50", Nonce: 1}
+	if hex.EncodeToString(a.Hash(false)) != hex.EncodeToString(b.Hash(false)) {
+		t.Errorf("hash of 0.5 and 0.50 should be equal")
+	}
+}
+
+func TestTransactionDecoder_AmountPrecision(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	_, address := newTestKey(t)
+	server.setAccount(address, Symbol, "1", 0)
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", address)
+	decoder := wm.GetTransactionDecoder()
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:      map[string]string{testAddress("dest"): "0.123456789"},
+	}
+	err := decoder.CreateRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrCreateRawTransactionFailed {
+		t.Fatalf("err = %v, want create raw transaction failed", err)
+	}
+
+	rawTx.To = map[string]string{testAddress("dest"): "0.50"}
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	txs, err := DecodeRawTransactions(rawTx.RawHex)
+	if err != nil {
+		t.Fatalf("DecodeRawTransactions failed, err: %v", err)
+	}
+	if len(txs) != 1 || txs[0].Amount != "0.5" {
+		t.Errorf("amount = %s, want 0.5", txs[0].Amount)
+	}
+}
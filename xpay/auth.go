@@ -0,0 +1,114 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/imroc/req"
+)
+
+const (
+	defaultSignatureHeader = "X-Signature"
+	defaultTimestampHeader = "X-Timestamp"
+)
+
+//ClientAuth API认证配置，所有值均不会输出到日志
+type ClientAuth struct {
+	Headers         map[string]string //固定请求头，例如API Key
+	BearerToken     string            //Authorization: Bearer
+	SignSecret      string            //HMAC-SHA256签名密钥，为空不签名
+	SignatureHeader string            //签名请求头
+	TimestampHeader string            //时间戳请求头
+}
+
+//NewClientAuth 创建默认的认证配置
+func NewClientAuth() *ClientAuth {
+	return &ClientAuth{
+		Headers:         make(map[string]string),
+		SignatureHeader: defaultSignatureHeader,
+		TimestampHeader: defaultTimestampHeader,
+	}
+}
+
+//ParseHeader 解析请求头配置，格式：名称:值
+func ParseHeader(s string) (string, string, error) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid header: %s", s)
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), nil
+}
+
+//SignMessage 签名原文：请求方法\n请求路径\n时间戳\n请求体
+func SignMessage(method, requestURI, timestamp, body string) string {
+	return strings.Join([]string{strings.ToUpper(method), requestURI, timestamp, body}, "\n")
+}
+
+//Sign 计算HMAC-SHA256签名，返回16进制字符串
+func (auth *ClientAuth) Sign(method, requestURI, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(auth.SignSecret))
+	mac.Write([]byte(SignMessage(method, requestURI, timestamp, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+//header 生成请求的认证头
+func (auth *ClientAuth) header(method, rawurl, body string, now time.Time) req.Header {
+	header := req.Header{}
+	if auth == nil {
+		return header
+	}
+
+	for k, v := range auth.Headers {
+		header[k] = v
+	}
+
+	if auth.BearerToken != "" {
+		header["Authorization"] = "Bearer " + auth.BearerToken
+	}
+
+	if auth.SignSecret != "" {
+		requestURI := rawurl
+		if u, err := url.Parse(rawurl); err == nil {
+			requestURI = u.RequestURI()
+		}
+		timestamp := strconv.FormatInt(now.Unix(), 10)
+		header[auth.TimestampHeader] = timestamp
+		header[auth.SignatureHeader] = auth.Sign(method, requestURI, timestamp, body)
+	}
+
+	return header
+}
+
+//encodeParam 将请求参数编码为查询字符串或表单，保证签名的内容与发送的一致
+func encodeParam(param interface{}) string {
+	p, ok := param.(req.Param)
+	if !ok || len(p) == 0 {
+		return ""
+	}
+	values := url.Values{}
+	for k, v := range p {
+		values.Set(k, fmt.Sprint(v))
+	}
+	return values.Encode()
+}
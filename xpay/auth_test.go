@@ -0,0 +1,50 @@
+package xpay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/imroc/req"
+)
+
+func TestClient_Auth(t *testing.T) {
+	auth := NewClientAuth()
+	auth.Headers["X-Api-Key"] = "key"
+	auth.BearerToken = "token"
+	auth.SignSecret = "secret"
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		if r.Header.Get("X-Api-Key") != "key" || r.Header.Get("Authorization") != "Bearer token" {
+			w.Write([]byte(`{"error":{"message":"unauthorized"}}`))
+			return
+		}
+		sig := auth.Sign(r.Method, r.URL.RequestURI(), r.Header.Get(defaultTimestampHeader), string(body))
+		if sig != r.Header.Get(defaultSignatureHeader) {
+			w.Write([]byte(`{"error":{"message":"invalid signature"}}`))
+			return
+		}
+		form, _ := url.ParseQuery(string(body))
+		w.Write([]byte(`{"symbol":"` + form.Get("symbol") + `"}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL+"/api", false)
+	c.SetAuth(auth)
+
+	result, err := c.call("POST", "coin/new", req.Param{"symbol": "XIF"})
+	if err != nil {
+		t.Errorf("POST failed, err: %v", err)
+		return
+	}
+	if result.Get("symbol").String() != "XIF" {
+		t.Errorf("unexpected result: %s", result.Raw)
+	}
+
+	if _, err := c.call("GET", "coin/blocks/latest", req.Param{"a": "1"}); err != nil {
+		t.Errorf("GET failed, err: %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"strings"
+)
+
+//BlockIntegrityError 区块与交易列表不一致，扫描器不推进本地高度
+type BlockIntegrityError struct {
+	Height  uint64
+	Reasons []string
+}
+
+func (e *BlockIntegrityError) Error() string {
+	return fmt.Sprintf("block %d is inconsistent: %s", e.Height, strings.Join(e.Reasons, "; "))
+}
+
+//validateBlock 检查区块的高度、hash和交易列表
+func validateBlock(block *Block, height uint64) error {
+	reasons := make([]string, 0)
+	if block.Height != height {
+		reasons = append(reasons, fmt.Sprintf("block claims height %d", block.Height))
+	}
+	if block.Hash == "" {
+		reasons = append(reasons, "block hash is empty")
+	} else if block.Hash == block.LastHash {
+		reasons = append(reasons, "block hash equals last hash")
+	}
+	seen := make(map[string]bool, len(block.Txns))
+	for _, txid := range block.Txns {
+		if seen[txid] {
+			reasons = append(reasons, fmt.Sprintf("transaction %s is listed twice", txid))
+		}
+		seen[txid] = true
+	}
+	if len(reasons) > 0 {
+		return &BlockIntegrityError{Height: height, Reasons: reasons}
+	}
+	return nil
+}
+
+//checkExtractResult 检查交易的区块高度和hash与所在区块一致，返回不一致的原因
+func checkExtractResult(height uint64, blockHash string, result *ExtractResult) string {
+	if !result.Success {
+		return ""
+	}
+	if result.BlockHeight != height {
+		return fmt.Sprintf("transaction %s claims height %d", result.TxID, result.BlockHeight)
+	}
+	if result.BlockHash != "" && blockHash != "" && result.BlockHash != blockHash {
+		return fmt.Sprintf("transaction %s claims block hash %s", result.TxID, result.BlockHash)
+	}
+	return ""
+}
@@ -0,0 +1,139 @@
+package xpay
+
+import (
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestBlockScanner_BlockIntegrity(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+	h101 := server.addBlock("h101", "tx1")
+	server.addTransaction("tx1", h101, "sender", "own", Symbol, "1", "")
+	//交易声称在另一个高度
+	h102 := server.addBlock("h102", "tx2")
+	tx2 := server.addTransaction("tx2", h102, "sender", "own", Symbol, "2", "")
+	server.mu.Lock()
+	tx2["block"] = "99"
+	server.mu.Unlock()
+
+	wm := newMockWalletManager(t, server, "")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "acc1", target.Address == "own"
+	})
+	observer := newMockObserver()
+	bs.AddObserver(observer)
+	bs.SaveLocalBlockHead(100, "h100")
+	bs.Scanning = true
+
+	bs.ScanBlockTask()
+	if height, _, _ := bs.GetLocalBlockHead(); height != h101 {
+		t.Fatalf("local head = %d, want %d", height, h101)
+	}
+	//重扫最近区块会重复通知，按txid统计
+	notified := make(map[string]bool)
+	observer.mu.Lock()
+	for _, data := range observer.data["acc1"] {
+		notified[data.Transaction.TxID] = true
+	}
+	observer.mu.Unlock()
+	if len(notified) != 1 || !notified["tx1"] {
+		t.Errorf("notified = %v, inconsistent transaction should not be notified", notified)
+	}
+
+	//修复后继续扫描
+	server.mu.Lock()
+	tx2["block"] = "102"
+	server.mu.Unlock()
+	bs.ScanBlockTask()
+	if height, _, _ := bs.GetLocalBlockHead(); height != h102 {
+		t.Fatalf("local head = %d, want %d", height, h102)
+	}
+
+	//交易重复出现在新区块
+	h103 := server.addBlock("h103", "tx1")
+	bs.ScanBlockTask()
+	if height, _, _ := bs.GetLocalBlockHead(); height != h102 {
+		t.Errorf("local head = %d, duplicate transaction in block %d should stop scanner", height, h103)
+	}
+}
+
+func TestBlockScanner_BlockIntegrityMixed(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+	//同一区块中一笔一致、一笔不一致
+	h101 := server.addBlock("h101", "tx1", "tx2")
+	server.addTransaction("tx1", h101, "sender", "own", Symbol, "1", "")
+	tx2 := server.addTransaction("tx2", h101, "sender", "own", Symbol, "2", "")
+	server.mu.Lock()
+	tx2["block"] = "99"
+	server.mu.Unlock()
+
+	wm := newMockWalletManager(t, server, "")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "acc1", target.Address == "own"
+	})
+	observer := newMockObserver()
+	bs.AddObserver(observer)
+	bs.SaveLocalBlockHead(100, "h100")
+	bs.Scanning = true
+
+	//区块被挂起期间重试多次，一致的交易也不能入账
+	for i := 0; i < 2; i++ {
+		bs.ScanBlockTask()
+		if height, _, _ := bs.GetLocalBlockHead(); height != 100 {
+			t.Fatalf("local head = %d, want 100", height)
+		}
+	}
+	observer.mu.Lock()
+	held := len(observer.data["acc1"])
+	observer.mu.Unlock()
+	if held != 0 {
+		t.Errorf("notified %d transactions from held block, want 0", held)
+	}
+	if block := bs.blockStore.get(h101); block != nil {
+		t.Errorf("held block contents should not be saved")
+	}
+
+	//修复后整块通知，重扫最近区块会重复通知，按txid统计
+	server.mu.Lock()
+	tx2["block"] = "101"
+	server.mu.Unlock()
+	bs.ScanBlockTask()
+	if height, _, _ := bs.GetLocalBlockHead(); height != h101 {
+		t.Fatalf("local head = %d, want %d", height, h101)
+	}
+	notified := make(map[string]bool)
+	observer.mu.Lock()
+	for _, data := range observer.data["acc1"] {
+		notified[data.Transaction.TxID] = true
+	}
+	observer.mu.Unlock()
+	if len(notified) != 2 || !notified["tx1"] || !notified["tx2"] {
+		t.Errorf("notified = %v, want tx1 and tx2", notified)
+	}
+	if block := bs.blockStore.get(h101); block == nil || len(block.Accounts["acc1"]) != 2 {
+		t.Errorf("block contents = %+v, want both transactions under acc1", block)
+	}
+}
+
+func TestValidateBlock(t *testing.T) {
+	block := &Block{Height: 10, Hash: "h10", LastHash: "h9", Txns: []string{"a", "b"}}
+	if err := validateBlock(block, 10); err != nil {
+		t.Errorf("validateBlock failed, err: %v", err)
+	}
+	if err := validateBlock(block, 11); err == nil {
+		t.Errorf("block with another height should fail")
+	}
+	block.Txns = []string{"a", "a"}
+	if err := validateBlock(block, 10); err == nil {
+		t.Errorf("block with duplicate transactions should fail")
+	}
+}
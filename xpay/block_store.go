@@ -0,0 +1,261 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//defaultBlockStoreWindow 本地保存区块内容的数量，用于重复交易检查和分叉回滚
+const defaultBlockStoreWindow = 1000
+
+//LocalBlock 本地保存的区块内容
+type LocalBlock struct {
+	Height   uint64              `json:"height"`
+	Hash     string              `json:"hash"`
+	TxIDs    []string            `json:"txids"`
+	Accounts map[string][]string `json:"accounts"`           //各账户（sourceKey）提取到的交易
+	Rejected []string            `json:"rejected,omitempty"` //签名校验失败没有提取的交易
+}
+
+//ForkedBlock 分叉区块及需要回滚的交易
+//ContentsMissing为true时本地没有该区块的内容，TxIDs和Reverted为空不代表没有需要回滚的交易
+type ForkedBlock struct {
+	Header          *openwallet.BlockHeader
+	TxIDs           []string
+	Reverted        map[string][]string //各账户（sourceKey）需要回滚的交易
+	ContentsMissing bool                //本地没有区块内容，观测者需要按区块高度自行核对
+}
+
+//ForkObserver 观测者实现该接口时，分叉后收到需要回滚的交易
+type ForkObserver interface {
+	BlockForkNotify(fork *ForkedBlock) error
+}
+
+//blockStore 最近区块的内容，DataDir不为空时每个区块保存为一个文件
+type blockStore struct {
+	mu     sync.Mutex
+	dir    string
+	blocks map[uint64]*LocalBlock
+	txs    map[string]uint64
+	window uint64
+}
+
+func newBlockStore(window uint64) *blockStore {
+	return &blockStore{
+		blocks: make(map[uint64]*LocalBlock),
+		txs:    make(map[string]uint64),
+		window: window,
+	}
+}
+
+//readLocalBlocks 读取dataDir的name目录中保存的区块，不修改当前内容，dataDir为空时目录为空
+func readLocalBlocks(dataDir, name string) (string, []*LocalBlock, error) {
+	if dataDir == "" {
+		return "", nil, nil
+	}
+	dir := filepath.Join(dataDir, name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, err
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	blocks := make([]*LocalBlock, 0, len(files))
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return "", nil, err
+		}
+		block := &LocalBlock{}
+		if err := json.Unmarshal(data, block); err != nil {
+			return "", nil, fmt.Errorf("local block %s is broken: %v", f.Name(), err)
+		}
+		blocks = append(blocks, block)
+	}
+	return dir, blocks, nil
+}
+
+//use 切换到readLocalBlocks读取的目录并加入其中的区块，dir为空时只保存在内存
+func (store *blockStore) use(dir string, blocks []*LocalBlock) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	store.dir = dir
+	for _, block := range blocks {
+		store.putLocked(block)
+	}
+}
+
+func (store *blockStore) path(height uint64) string {
+	return filepath.Join(store.dir, strconv.FormatUint(height, 10)+".json")
+}
+
+//duplicates 已经记录在其他区块中的交易
+func (store *blockStore) duplicates(height uint64, txids []string) []string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	reasons := make([]string, 0)
+	for _, txid := range txids {
+		if h, exist := store.txs[txid]; exist && h != height {
+			reasons = append(reasons, fmt.Sprintf("transaction %s is already in block %d", txid, h))
+		}
+	}
+	return reasons
+}
+
+//save 保存区块内容，并删除窗口之外的区块
+func (store *blockStore) save(block *LocalBlock) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if store.dir != "" {
+		data, err := json.Marshal(block)
+		if err != nil {
+			return err
+		}
+		tmp := store.path(block.Height) + ".tmp"
+		if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, store.path(block.Height)); err != nil {
+			return err
+		}
+	}
+
+	store.putLocked(block)
+	for h := range store.blocks {
+		if h+store.window < block.Height {
+			store.removeLocked(h)
+		}
+	}
+	return nil
+}
+
+//get 本地保存的区块内容
+func (store *blockStore) get(height uint64) *LocalBlock {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.blocks[height]
+}
+
+//rejected 交易是否已经在同一区块中因签名无效被拒绝
+func (store *blockStore) rejected(height uint64, hash, txid string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	block, exist := store.blocks[height]
+	if !exist || block.Hash != hash {
+		return false
+	}
+	for _, r := range block.Rejected {
+		if r == txid {
+			return true
+		}
+	}
+	return false
+}
+
+//removeFrom 删除从height开始的区块，用于分叉回滚
+func (store *blockStore) removeFrom(height uint64) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for h := range store.blocks {
+		if h >= height {
+			store.removeLocked(h)
+		}
+	}
+}
+
+func (store *blockStore) putLocked(block *LocalBlock) {
+	store.unindexLocked(block.Height)
+	store.blocks[block.Height] = block
+	for _, txid := range block.TxIDs {
+		store.txs[txid] = block.Height
+	}
+}
+
+func (store *blockStore) removeLocked(height uint64) {
+	if _, exist := store.blocks[height]; !exist {
+		return
+	}
+	store.unindexLocked(height)
+	if store.dir != "" {
+		os.Remove(store.path(height))
+	}
+}
+
+//unindexLocked 只从内存中删除区块，不删除文件
+func (store *blockStore) unindexLocked(height uint64) {
+	block, exist := store.blocks[height]
+	if !exist {
+		return
+	}
+	for _, txid := range block.TxIDs {
+		if store.txs[txid] == height {
+			delete(store.txs, txid)
+		}
+	}
+	delete(store.blocks, height)
+}
+
+//GetLocalBlockContents 本地保存的区块交易和各账户提取到的交易
+func (bs *BlockScanner) GetLocalBlockContents(height uint64) (*LocalBlock, bool) {
+	block := bs.blockStore.get(height)
+	return block, block != nil
+}
+
+//saveLocalBlockContents 保存区块内容，保存失败只记录日志，不影响扫描
+func (bs *BlockScanner) saveLocalBlockContents(block *LocalBlock) {
+	if err := bs.blockStore.save(block); err != nil {
+		bs.wm.Log.Std.Error("block scanner save local block %d failed: %v", block.Height, err)
+	}
+}
+
+//forkedBlock 分叉区块需要回滚的交易
+func forkedBlock(header *openwallet.BlockHeader, local *LocalBlock) *ForkedBlock {
+	fork := &ForkedBlock{
+		Header:   header,
+		TxIDs:    make([]string, 0),
+		Reverted: make(map[string][]string),
+	}
+	//本地内容属于同高度的其他区块时同样视为缺失
+	if local == nil || local.Hash != header.Hash {
+		fork.ContentsMissing = true
+		return fork
+	}
+	fork.TxIDs = append(fork.TxIDs, local.TxIDs...)
+	for account, txids := range local.Accounts {
+		reverted := append([]string(nil), txids...)
+		sort.Strings(reverted)
+		fork.Reverted[account] = reverted
+	}
+	return fork
+}
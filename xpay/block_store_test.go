@@ -0,0 +1,97 @@
+package xpay
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//mockForkObserver 记录分叉回滚的交易
+type mockForkObserver struct {
+	*mockObserver
+	mu    sync.Mutex
+	forks []*ForkedBlock
+}
+
+func (o *mockForkObserver) BlockForkNotify(fork *ForkedBlock) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.forks = append(o.forks, fork)
+	return nil
+}
+
+func TestBlockScanner_ForkReversal(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+	h101 := server.addBlock("h101", "tx1", "tx2")
+	server.addTransaction("tx1", h101, "sender", "own", Symbol, "1", "")
+	server.addTransaction("tx2", h101, "sender", "other", Symbol, "2", "")
+
+	dataDir := newTempDir(t)
+	wm := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "acc1", target.Address == "own"
+	})
+	observer := &mockForkObserver{mockObserver: newMockObserver()}
+	bs.AddObserver(observer)
+	bs.SaveLocalBlockHead(100, "h100")
+	bs.Scanning = true
+
+	bs.ScanBlockTask()
+	local, ok := bs.GetLocalBlockContents(h101)
+	if !ok || len(local.TxIDs) != 2 || len(local.Accounts["acc1"]) != 1 {
+		t.Fatalf("local block contents: %+v", local)
+	}
+
+	//重启后从DataDir加载
+	wm2 := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	if local, ok := wm2.Blockscanner.(*BlockScanner).GetLocalBlockContents(h101); !ok || local.Hash != "h101" {
+		t.Fatalf("local block is not persisted: %+v", local)
+	}
+
+	//101分叉为没有交易的区块
+	server.mu.Lock()
+	server.blocks[h101]["hash"] = "h101b"
+	server.blocks[h101]["txns"] = []string{}
+	server.mu.Unlock()
+	server.addBlock("h102")
+
+	bs.ScanBlockTask()
+	observer.mu.Lock()
+	forks := observer.forks
+	observer.mu.Unlock()
+	if len(forks) != 1 {
+		t.Fatalf("fork notifications = %d, want 1", len(forks))
+	}
+	fork := forks[0]
+	if fork.Header.Height != h101 || !fork.Header.Fork || len(fork.TxIDs) != 2 {
+		t.Errorf("unexpected fork: %+v", fork)
+	}
+	if fork.ContentsMissing {
+		t.Errorf("fork contents are stored locally but marked missing")
+	}
+	if reverted := fork.Reverted["acc1"]; len(reverted) != 1 || reverted[0] != "tx1" {
+		t.Errorf("reverted = %v, want [tx1]", fork.Reverted)
+	}
+	if local, _ := bs.GetLocalBlockContents(h101); local == nil || local.Hash != "h101b" {
+		t.Errorf("forked block is not replaced: %+v", local)
+	}
+}
+
+func TestForkedBlock_ContentsMissing(t *testing.T) {
+	header := &openwallet.BlockHeader{Height: 101, Hash: "h101", Fork: true}
+	local := &LocalBlock{Height: 101, Hash: "h101", TxIDs: []string{"tx1"}, Accounts: map[string][]string{"acc1": {"tx1"}}}
+
+	if fork := forkedBlock(header, local); fork.ContentsMissing || len(fork.Reverted["acc1"]) != 1 {
+		t.Errorf("stored contents: %+v", fork)
+	}
+	for _, local := range []*LocalBlock{nil, {Height: 101, Hash: "h101b"}} {
+		if fork := forkedBlock(header, local); !fork.ContentsMissing || len(fork.TxIDs) != 0 || len(fork.Reverted) != 0 {
+			t.Errorf("missing contents %+v: %+v", local, fork)
+		}
+	}
+}
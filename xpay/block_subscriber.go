@@ -0,0 +1,184 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+const (
+	defaultBlockNotifyReconnect   = 5 * time.Second  // 断线后重连间隔
+	defaultBlockNotifyIdleTimeout = 60 * time.Second // 超过该时间没有收到任何数据视为断线，没有收到新区块事件时恢复轮询
+)
+
+//BlockSubscriber 通过SSE订阅新区块推送，收到推送立即触发扫描
+//连接断开期间由定时任务轮询，重连成功后恢复推送
+//连接保持但空闲超时内没有收到新区块事件（只有保持连接的注释）时，同样由定时任务轮询
+type BlockSubscriber struct {
+	wm          *WalletManager
+	url         string        //订阅地址，相对路径基于ServerAPI
+	reconnect   time.Duration //断线重连间隔
+	idleTimeout time.Duration //空闲超时
+	onBlock     func()        //新区块回调
+	connected   int32
+	lastEvent   int64 //最近一次收到新区块事件或连接成功的时间，UnixNano
+	quit        chan struct{}
+	wg          sync.WaitGroup
+}
+
+//NewBlockSubscriber 创建新区块订阅
+func NewBlockSubscriber(wm *WalletManager, url string, onBlock func()) *BlockSubscriber {
+	s := BlockSubscriber{
+		wm:          wm,
+		url:         url,
+		reconnect:   wm.CurrentConfig().BlockNotifyReconnect,
+		idleTimeout: wm.CurrentConfig().BlockNotifyIdleTimeout,
+		onBlock:     onBlock,
+	}
+	if s.reconnect <= 0 {
+		s.reconnect = defaultBlockNotifyReconnect
+	}
+	if s.idleTimeout <= 0 {
+		s.idleTimeout = defaultBlockNotifyIdleTimeout
+	}
+	return &s
+}
+
+//Start 开始订阅
+func (s *BlockSubscriber) Start() {
+	s.quit = make(chan struct{})
+	s.wg.Add(1)
+	go s.run(s.quit)
+}
+
+//Stop 停止订阅，等待连接关闭
+func (s *BlockSubscriber) Stop() {
+	if s.quit == nil {
+		return
+	}
+	close(s.quit)
+	s.wg.Wait()
+	s.quit = nil
+}
+
+//Connected 推送是否正常：连接正常，并且空闲超时内收到过新区块事件
+func (s *BlockSubscriber) Connected() bool {
+	if atomic.LoadInt32(&s.connected) != 1 {
+		return false
+	}
+	return time.Since(time.Unix(0, atomic.LoadInt64(&s.lastEvent))) < s.idleTimeout
+}
+
+func (s *BlockSubscriber) run(quit chan struct{}) {
+	defer s.wg.Done()
+
+	for {
+		err := s.subscribe(quit)
+		atomic.StoreInt32(&s.connected, 0)
+
+		select {
+		case <-quit:
+			return
+		default:
+		}
+
+		s.wm.Log.Std.Warning("block subscription disconnected, fallback to polling, err: %v", err)
+
+		select {
+		case <-quit:
+			return
+		case <-time.After(s.reconnect):
+		}
+	}
+}
+
+//subscribe 建立连接并读取推送事件，直到连接断开
+func (s *BlockSubscriber) subscribe(quit chan struct{}) error {
+
+	if s.wm.client() == nil {
+		return fmt.Errorf("API url is not setup. ")
+	}
+
+	resp, err := s.wm.client().stream(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	//退出或空闲超时时关闭连接，结束读取
+	done := make(chan struct{})
+	defer close(done)
+	idle := time.AfterFunc(s.idleTimeout, func() {
+		resp.Body.Close()
+	})
+	defer idle.Stop()
+	go func() {
+		select {
+		case <-quit:
+			resp.Body.Close()
+		case <-done:
+		}
+	}()
+
+	atomic.StoreInt64(&s.lastEvent, time.Now().UnixNano())
+	atomic.StoreInt32(&s.connected, 1)
+	s.wm.Log.Std.Info("block subscription connected: %s", s.url)
+
+	//连接成功先扫描一次，补上断线期间的区块
+	go s.onBlock()
+
+	var (
+		reader = bufio.NewReader(resp.Body)
+		data   = make([]string, 0)
+	)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		idle.Reset(s.idleTimeout)
+
+		line = strings.TrimRight(line, "\r\n")
+		switch {
+		case line == "":
+			//空行表示一个事件结束
+			if len(data) > 0 {
+				s.notify(strings.Join(data, "\n"))
+				data = data[:0]
+			}
+		case strings.HasPrefix(line, ":"):
+			//注释行，用于保持连接
+		case strings.HasPrefix(line, "data:"):
+			data = append(data, strings.TrimSpace(strings.TrimPrefix(line, "data:")))
+		}
+	}
+}
+
+//notify 收到新区块事件
+func (s *BlockSubscriber) notify(data string) {
+	atomic.StoreInt64(&s.lastEvent, time.Now().UnixNano())
+	if height := gjson.Get(data, "id").Uint(); height > 0 {
+		s.wm.Log.Std.Debug("block subscription received new block: %d", height)
+	}
+	go s.onBlock()
+}
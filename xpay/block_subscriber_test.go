@@ -0,0 +1,98 @@
+package xpay
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestBlockScanner_Subscribe(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+	server.addBlock("h101")
+
+	wm := newMockWalletManager(t, server, "BlockNotifyURL = coin/blocks/subscribe\nBlockNotifyReconnect = 1\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	//不依赖轮询
+	bs.PeriodOfTask = time.Hour
+	bs.SetTask(bs.pollTask)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "", false
+	})
+	observer := newMockObserver()
+	bs.AddObserver(observer)
+
+	if err := bs.Run(); err != nil {
+		t.Fatalf("Run failed, err: %v", err)
+	}
+	defer bs.CloseBlockScanner()
+
+	//连接成功后立即扫描到最新区块
+	if !waitFor(3*time.Second, func() bool { return observer.scannedHeight() == 101 }) {
+		t.Fatalf("initial scan not triggered, scanned: %d", observer.scannedHeight())
+	}
+
+	//推送新区块后立即扫描
+	height := server.addBlock("h102")
+	if !waitFor(3*time.Second, func() bool { return observer.scannedHeight() == height }) {
+		t.Fatalf("push scan not triggered, scanned: %d", observer.scannedHeight())
+	}
+
+	//断线后重连，补扫断线期间的区块
+	server.closeStreams()
+	if !waitFor(3*time.Second, func() bool { return !bs.subscriber.Connected() }) {
+		t.Fatalf("subscriber should be disconnected")
+	}
+	height = server.addBlock("h103")
+	if !waitFor(5*time.Second, func() bool { return observer.scannedHeight() == height }) {
+		t.Fatalf("reconnect scan not triggered, scanned: %d", observer.scannedHeight())
+	}
+}
+
+func TestBlockScanner_SubscribeSilent(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+
+	wm := newMockWalletManager(t, server, "BlockNotifyURL = coin/blocks/subscribe\nBlockNotifyIdleTimeout = 1\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	//轮询由测试调用pollTask模拟
+	bs.PeriodOfTask = time.Hour
+	bs.SetTask(bs.pollTask)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "", false
+	})
+	observer := newMockObserver()
+	bs.AddObserver(observer)
+
+	if err := bs.Run(); err != nil {
+		t.Fatalf("Run failed, err: %v", err)
+	}
+	defer bs.CloseBlockScanner()
+
+	if !waitFor(3*time.Second, func() bool { return observer.scannedHeight() == 100 }) {
+		t.Fatalf("initial scan not triggered, scanned: %d", observer.scannedHeight())
+	}
+
+	//连接只有保持连接的注释，没有新区块事件，超时后恢复轮询
+	server.setSilent(true)
+	height := server.addBlock("h101")
+	bs.pollTask()
+	if observer.scannedHeight() == height {
+		t.Fatalf("poll scan should be skipped before idle timeout")
+	}
+	if !waitFor(3*time.Second, func() bool {
+		bs.pollTask()
+		return observer.scannedHeight() == height
+	}) {
+		t.Fatalf("poll scan not resumed, scanned: %d", observer.scannedHeight())
+	}
+	if atomic.LoadInt32(&bs.subscriber.connected) != 1 || bs.subscriber.Connected() {
+		t.Errorf("subscriber should stay connected but be treated as stale")
+	}
+}
@@ -20,21 +20,36 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 const (
 	maxExtractingSize = 10 // thread count
+	//defaultScanPeriod 轮询扫描的间隔
+	defaultScanPeriod = 5 * time.Second
+	//defaultRescanLastBlockCount 每次扫描后重扫的最近区块数量
+	defaultRescanLastBlockCount = 3
+	//UnmatchedMemoSourceKey 共享充值地址备注无法匹配账户时，通知观测者使用的sourceKey
+	UnmatchedMemoSourceKey = "xpay-unmatched-memo"
 )
 
 //BlockScanner block scanner
 type BlockScanner struct {
 	*openwallet.BlockScannerBase
 
-	CurrentBlockHeight   uint64         //当前区块高度
-	extractingCH         chan struct{}  //扫描工作令牌
-	wm                   *WalletManager //钱包管理者
-	RescanLastBlockCount uint64         //重扫上N个区块数量
+	CurrentBlockHeight   uint64           //当前区块高度
+	extractingCH         chan struct{}    //扫描工作令牌
+	wm                   *WalletManager   //钱包管理者
+	RescanLastBlockCount uint64           //重扫上N个区块数量
+	subscriber           *BlockSubscriber //新区块推送订阅
+	subscriberMu         sync.Mutex
+	scanLock             chan struct{}    //保证同一时间只有一个扫描任务
+	scanPending          int32            //扫描中收到的触发请求
+	blockStore           *blockStore      //最近区块的内容，检查重复交易和分叉回滚
+	appliedConfig        *WalletConfig    //扫描器当前应用的配置
+	senderNonces         senderNonceCache //校验签名时当前区块的发送者nonce
 }
 
 //ExtractResult extract result
@@ -45,6 +60,7 @@ type ExtractResult struct {
 	BlockHeight uint64
 	BlockTime   int64
 	Success     bool
+	rejected    bool //签名校验失败，交易不提取
 }
 
 //SaveResult result
@@ -61,15 +77,104 @@ func NewBlockScanner(wm *WalletManager) *BlockScanner {
 	}
 
 	bs.extractingCH = make(chan struct{}, maxExtractingSize)
+	bs.scanLock = make(chan struct{}, 1)
 	bs.wm = wm
-	bs.RescanLastBlockCount = 3
+	bs.RescanLastBlockCount = defaultRescanLastBlockCount
+	bs.blockStore = newBlockStore(defaultBlockStoreWindow)
 
 	// set task
-	bs.SetTask(bs.ScanBlockTask)
+	bs.SetTask(bs.pollTask)
 
 	return &bs
 }
 
+//Run 运行扫描，配置了推送地址时同时订阅新区块
+func (bs *BlockScanner) Run() error {
+	//启动前应用加载的配置，扫描周期在启动时生效
+	if !bs.Scanning {
+		bs.applyConfig()
+	}
+	err := bs.BlockScannerBase.Run()
+	if err != nil {
+		return err
+	}
+
+	bs.subscriberMu.Lock()
+	defer bs.subscriberMu.Unlock()
+	if bs.subscriber == nil && bs.wm.CurrentConfig().BlockNotifyURL != "" {
+		bs.subscriber = NewBlockSubscriber(bs.wm, bs.wm.CurrentConfig().BlockNotifyURL, bs.triggerScan)
+		bs.subscriber.Start()
+	}
+	return nil
+}
+
+//Stop 停止扫描和订阅
+func (bs *BlockScanner) Stop() error {
+	bs.stopSubscriber()
+	return bs.BlockScannerBase.Stop()
+}
+
+//CloseBlockScanner 关闭扫描器
+func (bs *BlockScanner) CloseBlockScanner() error {
+	bs.stopSubscriber()
+	return bs.BlockScannerBase.CloseBlockScanner()
+}
+
+func (bs *BlockScanner) stopSubscriber() {
+	bs.subscriberMu.Lock()
+	defer bs.subscriberMu.Unlock()
+	if bs.subscriber != nil {
+		bs.subscriber.Stop()
+		bs.subscriber = nil
+	}
+}
+
+//pollTask 定时扫描任务，推送正常时由推送触发扫描
+//推送断线，或者连接保持但空闲超时内没有新区块事件时轮询
+func (bs *BlockScanner) pollTask() {
+	if bs.subscriberConnected() {
+		return
+	}
+	bs.triggerScan()
+}
+
+//subscriberConnected 推送是否正常
+func (bs *BlockScanner) subscriberConnected() bool {
+	bs.subscriberMu.Lock()
+	defer bs.subscriberMu.Unlock()
+	return bs.subscriber != nil && bs.subscriber.Connected()
+}
+
+//triggerScan 执行扫描，正在扫描时合并为扫描结束后再执行一次
+func (bs *BlockScanner) triggerScan() {
+	for {
+		if !bs.Scanning {
+			return
+		}
+
+		select {
+		case bs.scanLock <- struct{}{}:
+		default:
+			atomic.StoreInt32(&bs.scanPending, 1)
+			return
+		}
+
+		for {
+			atomic.StoreInt32(&bs.scanPending, 0)
+			bs.ScanBlockTask()
+			if atomic.LoadInt32(&bs.scanPending) == 0 || !bs.Scanning {
+				break
+			}
+		}
+		<-bs.scanLock
+
+		//释放前收到的触发请求
+		if atomic.LoadInt32(&bs.scanPending) == 0 {
+			return
+		}
+	}
+}
+
 // ScanBlockTask scan block task
 func (bs *BlockScanner) ScanBlockTask() {
 
@@ -78,6 +183,15 @@ func (bs *BlockScanner) ScanBlockTask() {
 		currentHash   string
 	)
 
+	//重新加载的配置在扫描开始时生效，本次扫描中不再变化
+	cfg := bs.applyConfig()
+
+	//API熔断中，跳过本次扫描
+	if status := bs.wm.CircuitStatus(); status.State == CircuitOpen {
+		bs.wm.Log.Std.Debug("block scanner skipped, backend is unavailable: %s", status.LastError)
+		return
+	}
+
 	// get local block header
 	currentHeight, currentHash, err := bs.GetLocalBlockHead()
 
@@ -86,17 +200,20 @@ func (bs *BlockScanner) ScanBlockTask() {
 	}
 
 	if currentHeight == 0 {
-		bs.wm.Log.Std.Info("No records found in local, get current block as the local!")
+		bs.wm.Log.Std.Info("No records found in local, get start block as the local!")
 
-		headBlock, err := bs.wm.GetLatestBlock()
+		startBlock, err := bs.startBlock()
 		if err != nil {
-			bs.wm.Log.Std.Info("get head block error, err=%v", err)
+			bs.wm.Log.Std.Info("get start block error, err=%v", err)
+			return
 		}
 
-		currentHash = headBlock.LastHash
-		currentHeight = headBlock.Height - 1
+		currentHash = startBlock.LastHash
+		currentHeight = startBlock.Height - 1
 	}
 
+	scanned := uint64(0)
+
 	for {
 		if !bs.Scanning {
 			// stop scan
@@ -109,7 +226,11 @@ func (bs *BlockScanner) ScanBlockTask() {
 			break
 		}
 
-		maxBlockHeight := lastBlock.Height
+		//只扫描达到确认数的区块
+		maxBlockHeight := uint64(0)
+		if lastBlock.Height > cfg.ScanConfirmations {
+			maxBlockHeight = lastBlock.Height - cfg.ScanConfirmations
+		}
 
 		bs.wm.Log.Info("current block height:", currentHeight, " maxBlockHeight:", maxBlockHeight)
 		if uint64(currentHeight) >= maxBlockHeight {
@@ -117,6 +238,14 @@ func (bs *BlockScanner) ScanBlockTask() {
 			break
 		}
 
+		//每次扫描的区块数量达到上限，本次扫描结束后立即继续
+		if cfg.MaxBlocksPerScan > 0 && scanned >= cfg.MaxBlocksPerScan {
+			bs.wm.Log.Std.Info("block scanner has scanned %d blocks in this cycle", scanned)
+			atomic.StoreInt32(&bs.scanPending, 1)
+			break
+		}
+		scanned++
+
 		// next block
 		currentHeight = currentHeight + 1
 
@@ -136,6 +265,8 @@ func (bs *BlockScanner) ScanBlockTask() {
 
 			// get local fork bolck
 			forkBlock, _ := bs.GetLocalBlock(currentHeight - 1)
+			forkContents := bs.blockStore.get(currentHeight - 1)
+			bs.blockStore.removeFrom(currentHeight - 1)
 			// delete last unscan block
 			bs.DeleteUnscanRecord(currentHeight - 1)
 			currentHeight = currentHeight - 2 // scan back to last 2 block
@@ -164,15 +295,24 @@ func (bs *BlockScanner) ScanBlockTask() {
 
 			if forkBlock != nil {
 				//通知分叉区块给观测者，异步处理
-				bs.forkBlockNotify(forkBlock)
+				bs.forkBlockNotify(forkBlock, forkContents)
 			}
 
 		} else {
-			currentHash = block.Hash
-			err := bs.BatchExtractTransactions(uint64(currentHeight), currentHash, block.Timestamp.Unix(), block.Txns)
+			//区块不一致时不推进本地高度，下次扫描重试
+			err := validateBlock(block, currentHeight)
+			if err == nil {
+				err = bs.BatchExtractTransactions(uint64(currentHeight), block.Hash, block.Timestamp.Unix(), block.Txns)
+			}
+			if integrityErr, ok := err.(*BlockIntegrityError); ok {
+				bs.wm.Log.Std.Error("block scanner stops at height %d: %v", currentHeight-1, integrityErr)
+				currentHeight = currentHeight - 1
+				break
+			}
 			if err != nil {
 				bs.wm.Log.Std.Error("block scanner ran BatchExtractTransactions occured unexpected error: %v", err)
 			}
+			currentHash = block.Hash
 
 			//保存本地新高度
 			bs.SaveLocalBlockHead(currentHeight, currentHash)
@@ -183,8 +323,14 @@ func (bs *BlockScanner) ScanBlockTask() {
 	}
 
 	//重扫前N个块，为保证记录找到
-	for i := currentHeight - bs.RescanLastBlockCount; i <= currentHeight; i++ {
-		bs.scanBlock(i)
+	if bs.RescanLastBlockCount > 0 {
+		start := uint64(1)
+		if currentHeight > bs.RescanLastBlockCount {
+			start = currentHeight - bs.RescanLastBlockCount
+		}
+		for i := start; i <= currentHeight; i++ {
+			bs.scanBlock(i)
+		}
 	}
 
 	//重扫失败区块
@@ -192,11 +338,64 @@ func (bs *BlockScanner) ScanBlockTask() {
 
 }
 
-//newBlockNotify 获得新区块后，通知给观测者
-func (bs *BlockScanner) forkBlockNotify(block *Block) {
+//startBlock 本地没有扫描记录时的起始区块，配置了ScanStartHeight时从该高度开始，否则从最新的已确认区块开始
+func (bs *BlockScanner) startBlock() (*Block, error) {
+	if bs.wm.CurrentConfig().ScanStartHeight > 0 {
+		return bs.wm.GetBlock(bs.wm.CurrentConfig().ScanStartHeight)
+	}
+	headBlock, err := bs.wm.GetLatestBlock()
+	if err != nil {
+		return nil, err
+	}
+	if bs.wm.CurrentConfig().ScanConfirmations == 0 || headBlock.Height <= bs.wm.CurrentConfig().ScanConfirmations+1 {
+		return headBlock, nil
+	}
+	return bs.wm.GetBlock(headBlock.Height - bs.wm.CurrentConfig().ScanConfirmations)
+}
+
+//applyConfig 扫描开始时应用重新加载后的配置，返回本次扫描使用的配置
+func (bs *BlockScanner) applyConfig() *WalletConfig {
+	c := bs.wm.CurrentConfig()
+	if c != bs.appliedConfig {
+		bs.loadConfig(c)
+	}
+	return c
+}
+
+//loadConfig 应用扫描配置，扫描中修改轮询间隔需要重新运行扫描器才生效
+func (bs *BlockScanner) loadConfig(c *WalletConfig) {
+	bs.appliedConfig = c
+	if cap(bs.extractingCH) != c.ScanWorkers {
+		bs.extractingCH = make(chan struct{}, c.ScanWorkers)
+	}
+	bs.RescanLastBlockCount = c.RescanLastBlockCount
+	if bs.PeriodOfTask != c.ScanPeriod {
+		bs.PeriodOfTask = c.ScanPeriod
+		if bs.Scanning {
+			bs.wm.Log.Std.Warning("scan period %v takes effect after block scanner restarts", c.ScanPeriod)
+		} else {
+			bs.SetTask(bs.pollTask)
+		}
+	}
+}
+
+//forkBlockNotify 分叉区块通知给观测者，实现ForkObserver的观测者同时收到需要回滚的交易
+func (bs *BlockScanner) forkBlockNotify(block *Block, contents *LocalBlock) {
 	header := block.BlockHeader(bs.wm.Symbol())
 	header.Fork = true
 	bs.NewBlockNotify(header)
+
+	fork := forkedBlock(header, contents)
+	if fork.ContentsMissing {
+		bs.wm.Log.Std.Warning("block %d is forked but its contents are not stored locally, reverted transactions are unknown", header.Height)
+	}
+	for o := range bs.Observers {
+		if forkObserver, ok := o.(ForkObserver); ok {
+			if err := forkObserver.BlockForkNotify(fork); err != nil {
+				bs.wm.Log.Std.Error("BlockForkNotify unexpected error: %v", err)
+			}
+		}
+	}
 }
 
 //newBlockNotify 获得新区块后，通知给观测者
@@ -216,9 +415,18 @@ func (bs *BlockScanner) BatchExtractTransactions(blockHeight uint64, blockHash s
 	)
 
 	if len(txIDs) == 0 {
+		bs.saveLocalBlockContents(&LocalBlock{Height: blockHeight, Hash: blockHash, TxIDs: txIDs})
 		return nil
 	}
 
+	//交易已经出现在其他区块
+	if reasons := bs.blockStore.duplicates(blockHeight, txIDs); len(reasons) > 0 {
+		return &BlockIntegrityError{Height: blockHeight, Reasons: reasons}
+	}
+
+	inconsistent := make([]string, 0)
+	extracted := make([]ExtractResult, 0, len(txIDs))
+
 	bs.wm.Log.Std.Info("block scanner ready extract transactions total: %d ", len(txIDs))
 
 	//生产通道
@@ -229,22 +437,16 @@ func (bs *BlockScanner) BatchExtractTransactions(blockHeight uint64, blockHash s
 	worker := make(chan ExtractResult)
 	defer close(worker)
 
-	//保存工作
+	//保存工作，先收集整个区块的交易，检查通过后再通知
 	saveWork := func(height uint64, result chan ExtractResult) {
 		//回收创建的地址
 		for gets := range result {
 
-			if gets.Success {
-				notifyErr := bs.newExtractDataNotify(height, gets.extractData)
-				if notifyErr != nil {
-					failed++ //标记保存失败数
-					bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
-				}
+			if reason := checkExtractResult(height, blockHash, &gets); reason != "" {
+				//交易与区块不一致，不通知
+				inconsistent = append(inconsistent, reason)
 			} else {
-				//记录未扫区块
-				unscanRecord := openwallet.NewUnscanRecord(height, "", "", bs.wm.Symbol())
-				bs.SaveUnscanRecord(unscanRecord)
-				failed++ //标记保存失败数
+				extracted = append(extracted, gets)
 			}
 			//累计完成的线程数
 			done++
@@ -279,6 +481,37 @@ func (bs *BlockScanner) BatchExtractTransactions(blockHeight uint64, blockHash s
 	//以下使用生产消费模式
 	bs.extractRuntime(producer, worker, quit)
 
+	//区块有任意交易不一致时，整块不通知，等待重试
+	if len(inconsistent) > 0 {
+		return &BlockIntegrityError{Height: blockHeight, Reasons: inconsistent}
+	}
+
+	accounts := make(map[string][]string)
+	rejected := make([]string, 0)
+	for _, gets := range extracted {
+		if !gets.Success {
+			//记录未扫区块
+			unscanRecord := openwallet.NewUnscanRecord(blockHeight, "", "", bs.wm.Symbol())
+			bs.SaveUnscanRecord(unscanRecord)
+			failed++ //标记保存失败数
+			continue
+		}
+		if gets.rejected {
+			rejected = append(rejected, gets.TxID)
+		}
+		for sourceKey := range gets.extractData {
+			accounts[sourceKey] = append(accounts[sourceKey], gets.TxID)
+		}
+		notifyErr := bs.newExtractDataNotify(blockHeight, gets.extractData)
+		if notifyErr != nil {
+			failed++ //标记保存失败数
+			bs.wm.Log.Std.Info("newExtractDataNotify unexpected error: %v", notifyErr)
+		}
+	}
+
+	//保存区块内容，分叉时通知需要回滚的交易
+	bs.saveLocalBlockContents(&LocalBlock{Height: blockHeight, Hash: blockHash, TxIDs: txIDs, Accounts: accounts, Rejected: rejected})
+
 	if failed > 0 {
 		return fmt.Errorf("block scanner saveWork failed")
 	}
@@ -336,8 +569,11 @@ func (bs *BlockScanner) ExtractTransaction(txid string, scanTargetFunc openwalle
 	result.BlockHeight = transaction.BlockHeight
 	result.BlockTime = transaction.Timestamp.Unix()
 
-	//跳过不一致的symbol
-	if transaction.Symbol != bs.wm.Symbol() {
+	//记录链上的手续费
+	bs.wm.observeFeeTransaction(transaction)
+
+	//跳过没有symbol的交易，其他symbol作为平台资产提取
+	if transaction.Symbol == "" {
 		result.Success = true
 		return result
 	}
@@ -358,10 +594,18 @@ func (bs *BlockScanner) ExtractTransaction(txid string, scanTargetFunc openwalle
 		optType = 2
 	}
 
+	//共享充值地址的转入交易，按备注分配到账户
+	if optType == 2 && bs.wm.CurrentConfig().SharedDepositAddresses[to] {
+		if bs.verifyTransaction(transaction, &result) {
+			bs.extractSharedDeposit(transaction, &result, scanTargetFunc)
+		}
+		return result
+	}
+
 	//订阅地址为交易单中的发送者
 	accountID1, ok1 := scanTargetFunc(openwallet.ScanTarget{Address: transaction.Owner, Symbol: bs.wm.Symbol(), BalanceModelType: openwallet.BalanceModelTypeAddress})
 	//订阅地址为交易单中的接收者
-	if ok1 {
+	if ok1 && bs.verifyTransaction(transaction, &result) {
 		bs.InitExtractResult(accountID1, transaction, &result, optType)
 	}
 
@@ -369,6 +613,25 @@ func (bs *BlockScanner) ExtractTransaction(txid string, scanTargetFunc openwalle
 
 }
 
+//extractSharedDeposit 按 地址:备注 查找充值账户，找不到时以UnmatchedMemoSourceKey单独通知
+func (bs *BlockScanner) extractSharedDeposit(tx *Transaction, result *ExtractResult, scanTargetFunc openwallet.BlockScanTargetFunc) {
+
+	if tx.Memo != "" {
+		target := openwallet.ScanTarget{
+			Address:          MemoScanTarget(tx.To, tx.Memo),
+			Symbol:           bs.wm.Symbol(),
+			BalanceModelType: openwallet.BalanceModelTypeAddress,
+		}
+		if accountID, ok := scanTargetFunc(target); ok {
+			bs.InitExtractResult(accountID, tx, result, 2)
+			return
+		}
+	}
+
+	bs.wm.Log.Std.Warning("transaction %s to shared deposit address %s has unmatched memo: %q", tx.Hash, tx.To, tx.Memo)
+	bs.InitExtractResult(UnmatchedMemoSourceKey, tx, result, 2)
+}
+
 //InitExtractResult optType = 0: 输入输出提取，1: 输入提取，2：输出提取
 func (bs *BlockScanner) InitExtractResult(sourceKey string, tx *Transaction, result *ExtractResult, optType int64) {
 
@@ -379,14 +642,30 @@ func (bs *BlockScanner) InitExtractResult(sourceKey string, tx *Transaction, res
 
 	status := "1"
 	reason := ""
+	if tx.verifyErr != nil {
+		status = "0"
+		reason = fmt.Sprintf("signature verification failed: %v", tx.verifyErr)
+	}
 
 	amount_dec, _ := decimal.NewFromString(tx.Amount)
 	amount := amount_dec.Abs().String()
+	if canonical, err := NewAmount(amount_dec.Abs()); err == nil {
+		amount = canonical.String()
+	} else {
+		bs.wm.Log.Std.Warning("transaction %s amount is invalid: %v", tx.Hash, err)
+	}
 
 	coin := openwallet.Coin{
 		Symbol:     bs.wm.Symbol(),
 		IsContract: false,
 	}
+	//平台资产
+	if tx.Symbol != bs.wm.Symbol() {
+		contract := bs.wm.NewTokenContract(tx.Symbol)
+		coin.IsContract = true
+		coin.ContractID = contract.ContractID
+		coin.Contract = contract
+	}
 
 	from := tx.From
 	to := tx.To
@@ -435,7 +714,7 @@ func (bs *BlockScanner) extractTxInput(trx *Transaction, txExtractData *openwall
 
 	//主网from交易转账信息，第一个TxInput
 	txInput := &openwallet.TxInput{}
-	txInput.Recharge.Sid = openwallet.GenTxInputSID(tx.TxID, bs.wm.Symbol(), "", uint64(0))
+	txInput.Recharge.Sid = openwallet.GenTxInputSID(tx.TxID, bs.wm.Symbol(), coin.ContractID, uint64(0))
 	txInput.Recharge.TxID = tx.TxID
 	txInput.Recharge.Address = from
 	txInput.Recharge.Coin = coin
@@ -459,7 +738,7 @@ func (bs *BlockScanner) extractTxOutput(trx *Transaction, txExtractData *openwal
 
 	//主网to交易转账信息,只有一个TxOutPut
 	txOutput := &openwallet.TxOutPut{}
-	txOutput.Recharge.Sid = openwallet.GenTxOutPutSID(tx.TxID, bs.wm.Symbol(), "", uint64(0))
+	txOutput.Recharge.Sid = openwallet.GenTxOutPutSID(tx.TxID, bs.wm.Symbol(), coin.ContractID, uint64(0))
 	txOutput.Recharge.TxID = tx.TxID
 	txOutput.Recharge.Address = to
 	txOutput.Recharge.Coin = coin
@@ -521,6 +800,11 @@ func (bs *BlockScanner) scanBlock(height uint64) (*Block, error) {
 
 	bs.wm.Log.Std.Info("block scanner scanning height: %d ...", block.Height)
 
+	if err := validateBlock(block, height); err != nil {
+		bs.wm.Log.Std.Error("block scanner skipped height: %d, %v", height, err)
+		return nil, err
+	}
+
 	err = bs.BatchExtractTransactions(block.Height, block.Hash, block.Timestamp.Unix(), block.Txns)
 	if err != nil {
 		bs.wm.Log.Std.Info("block scanner can not extractRechargeRecords; unexpected error: %v", err)
@@ -555,6 +839,11 @@ func (bs *BlockScanner) GetGlobalMaxBlockHeight() uint64 {
 	return headBlock.Height
 }
 
+//MemoScanTarget 共享充值地址的扫描目标，格式：地址:备注
+func MemoScanTarget(address, memo string) string {
+	return address + ":" + memo
+}
+
 //GetScannedBlockHeight 获取已扫区块高度
 func (bs *BlockScanner) GetScannedBlockHeight() uint64 {
 	height, _, _ := bs.GetLocalBlockHead()
@@ -0,0 +1,91 @@
+package xpay
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestBlockScanner_SharedDepositMemo(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	height := server.addBlock("h100")
+	server.addTransaction("tx1", height, "sender", "shared", Symbol, "1", "user42")
+	server.addTransaction("tx2", height, "sender", "shared", Symbol, "2", "nobody")
+	server.addTransaction("tx3", height, "sender", "own", Symbol, "3", "any")
+
+	wm := newMockWalletManager(t, server, "SharedDepositAddresses = shared;other\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	targets := map[string]string{
+		MemoScanTarget("shared", "user42"): "acc42",
+		"own":                              "accOwn",
+		"shared":                           "accShared",
+	}
+	scanTarget := func(target openwallet.ScanTarget) (string, bool) {
+		accountID, ok := targets[target.Address]
+		return accountID, ok
+	}
+
+	tests := []struct {
+		txid      string
+		sourceKey string
+	}{
+		{"tx1", "acc42"},
+		{"tx2", UnmatchedMemoSourceKey},
+		{"tx3", "accOwn"},
+	}
+	for _, test := range tests {
+		result := bs.ExtractTransaction(test.txid, scanTarget)
+		if !result.Success {
+			t.Fatalf("extract %s failed", test.txid)
+		}
+		if len(result.extractData) != 1 || result.extractData[test.sourceKey] == nil {
+			t.Errorf("%s extracted to %v, want %s", test.txid, result.extractData, test.sourceKey)
+		}
+	}
+}
+
+func TestBlockScanner_ScanConfig(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	for i := 0; i < 10; i++ {
+		server.addBlock(fmt.Sprintf("h%d", 100+i))
+	}
+
+	wm := newMockWalletManager(t, server, "ScanStartHeight = 102\nScanConfirmations = 2\nMaxBlocksPerScan = 3\nRescanLastBlockCount = 0\nScanWorkers = 4\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "", false
+	})
+	bs.Scanning = true
+
+	//从102开始，每次最多3个区块，扫描配置在扫描开始时应用
+	bs.ScanBlockTask()
+	if cap(bs.extractingCH) != 4 || bs.RescanLastBlockCount != 0 {
+		t.Fatalf("scan config is not applied")
+	}
+	if height, _, _ := bs.GetLocalBlockHead(); height != 104 {
+		t.Fatalf("local head = %d, want 104", height)
+	}
+	//最新区块109，只扫到2个确认的107
+	bs.ScanBlockTask()
+	bs.ScanBlockTask()
+	if height, _, _ := bs.GetLocalBlockHead(); height != 107 {
+		t.Fatalf("local head = %d, want 107", height)
+	}
+
+	for _, extra := range []string{"ScanWorkers = 0\n", "ScanConfirmations = -1\n", "ScanPeriod = 0\n"} {
+		c, _ := config.NewConfigData("ini", []byte("ServerAPI = "+server.URL+"\nFixFees = 0.01\n"+extra))
+		if err := NewWalletManager().LoadAssetsConfig(c); err == nil {
+			t.Errorf("LoadAssetsConfig(%q) should fail", extra)
+		}
+	}
+
+	c, err := NewWalletManager().InitAssetsConfig()
+	if err != nil || c.DefaultInt("ScanWorkers", 0) != maxExtractingSize || c.DefaultInt("RescanLastBlockCount", 0) != defaultRescanLastBlockCount {
+		t.Errorf("InitAssetsConfig defaults: %v", err)
+	}
+}
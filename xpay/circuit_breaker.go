@@ -0,0 +1,157 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultCircuitFailureThreshold = 5                // 连续失败多少次后熔断
+	defaultCircuitOpenTimeout      = 30 * time.Second // 熔断后多久进入半开状态
+)
+
+//CircuitState 熔断器状态
+type CircuitState int
+
+const (
+	CircuitClosed   CircuitState = iota //正常
+	CircuitOpen                         //熔断，请求快速失败
+	CircuitHalfOpen                     //半开，只允许一个探测请求
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+//CircuitStatus 熔断器状态信息，用于健康检查
+type CircuitStatus struct {
+	State     CircuitState
+	Failures  int       //连续失败次数
+	OpenedAt  time.Time //最近一次熔断的时间
+	LastError string    //最近一次失败的原因
+}
+
+//BackendUnavailableError API熔断中，请求没有发出而直接失败
+type BackendUnavailableError struct {
+	RetryAfter time.Duration //距离下一次探测的时间
+	LastError  string        //导致熔断的错误
+}
+
+func (e *BackendUnavailableError) Error() string {
+	return fmt.Sprintf("backend unavailable, circuit breaker is open, retry after %v, last error: %s", e.RetryAfter, e.LastError)
+}
+
+//IsBackendUnavailable 是否熔断导致的错误
+func IsBackendUnavailable(err error) bool {
+	var target *BackendUnavailableError
+	return errors.As(err, &target)
+}
+
+//circuitBreaker API熔断器
+type circuitBreaker struct {
+	mu          sync.Mutex
+	threshold   int //连续失败阈值，0为不启用
+	openTimeout time.Duration
+	state       CircuitState
+	failures    int
+	openedAt    time.Time
+	probing     bool //半开状态下是否已有探测请求
+	lastError   string
+}
+
+func newCircuitBreaker(threshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold:   threshold,
+		openTimeout: openTimeout,
+		state:       CircuitClosed,
+	}
+}
+
+//allow 请求是否允许发出，不允许时返回BackendUnavailableError
+func (cb *circuitBreaker) allow() error {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 {
+		return nil
+	}
+
+	switch cb.state {
+	case CircuitOpen:
+		if wait := cb.openTimeout - time.Since(cb.openedAt); wait > 0 {
+			return &BackendUnavailableError{RetryAfter: wait, LastError: cb.lastError}
+		}
+		//熔断超时，放行一个探测请求
+		cb.state = CircuitHalfOpen
+		cb.probing = true
+	case CircuitHalfOpen:
+		if cb.probing {
+			return &BackendUnavailableError{LastError: cb.lastError}
+		}
+		cb.probing = true
+	}
+	return nil
+}
+
+//done 记录请求结果，err为空表示API可用
+func (cb *circuitBreaker) done(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.threshold <= 0 {
+		return
+	}
+
+	if err == nil {
+		cb.state = CircuitClosed
+		cb.failures = 0
+		cb.probing = false
+		return
+	}
+
+	cb.failures++
+	cb.lastError = err.Error()
+	if cb.state == CircuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+		cb.probing = false
+	}
+}
+
+//status 当前状态
+func (cb *circuitBreaker) status() CircuitStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	return CircuitStatus{
+		State:     cb.state,
+		Failures:  cb.failures,
+		OpenedAt:  cb.openedAt,
+		LastError: cb.lastError,
+	}
+}
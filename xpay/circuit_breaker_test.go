@@ -0,0 +1,49 @@
+package xpay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestClient_CircuitBreaker(t *testing.T) {
+	var healthy int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, false)
+	c.SetCircuitBreaker(2, 50*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		c.call("GET", "coin/blocks/latest", nil)
+	}
+	if state := c.CircuitStatus().State; state != CircuitOpen {
+		t.Errorf("circuit breaker should be open, got: %v", state)
+		return
+	}
+
+	_, err := c.call("GET", "coin/blocks/latest", nil)
+	if !IsBackendUnavailable(err) {
+		t.Errorf("call should fail fast, err: %v", err)
+		return
+	}
+
+	//熔断超时后，探测请求成功则恢复
+	atomic.StoreInt32(&healthy, 1)
+	time.Sleep(60 * time.Millisecond)
+	if _, err := c.call("GET", "coin/blocks/latest", nil); err != nil {
+		t.Errorf("probe call failed, err: %v", err)
+		return
+	}
+	if state := c.CircuitStatus().State; state != CircuitClosed {
+		t.Errorf("circuit breaker should be closed, got: %v", state)
+	}
+}
@@ -18,6 +18,7 @@ package xpay
 import (
 	"github.com/blocktree/go-owcrypt"
 	"github.com/shopspring/decimal"
+	"time"
 )
 
 const (
@@ -25,6 +26,11 @@ const (
 	Symbol    = "XIF"
 )
 
+const defaultMemoMaxLength = 256
+
+//defaultDataDir 默认的数据目录，相对于运行目录
+const defaultDataDir = "data/xif"
+
 type WalletConfig struct {
 
 	//币种
@@ -33,14 +39,90 @@ type WalletConfig struct {
 	configFilePath string
 	//配置文件名
 	configFileName string
+	//网络：mainnet, testnet, devnet
+	Network string
+	//校验网络的区块高度
+	NetworkCheckHeight uint64
+	//校验网络的区块hash，为空不校验
+	NetworkCheckHash string
 	//钱包服务API
 	ServerAPI string
 	//曲线类型
 	CurveType uint32
-	//数据目录
+	//数据目录，地址登记队列和最近区块保存在这里
 	DataDir string
 	//Fix Required Fee
 	FixFees decimal.Decimal
+	//接口默认限流
+	RateLimit EndpointLimit
+	//指定接口的限流，key为接口路径前缀
+	EndpointLimits map[string]EndpointLimit
+	//遇到429时最大重试次数
+	RateLimitRetries int
+	//API连续失败多少次后熔断，0为不启用
+	CircuitFailureThreshold int
+	//熔断后多久进入半开状态
+	CircuitOpenTimeout time.Duration
+	//API认证
+	Auth *ClientAuth
+	//HTTP传输配置
+	Transport *TransportConfig
+	//是否输出API请求日志
+	APIDebug bool
+	//API请求日志配置
+	DebugLog *DebugLogConfig
+	//新区块推送订阅地址（SSE），为空时只使用轮询
+	BlockNotifyURL string
+	//推送断线后重连间隔
+	BlockNotifyReconnect time.Duration
+	//推送空闲超时，超时视为断线
+	BlockNotifyIdleTimeout time.Duration
+	//没有单个地址足够出账时的处理方式：single, consolidate, split
+	FundingMode string
+	//默认的出账地址选择策略
+	SenderStrategy SenderStrategy
+	//指定账户的出账地址选择策略，key为账户ID
+	AccountSenderStrategies map[string]SenderStrategy
+	//出账地址选择策略的配置，key为账户ID，默认策略的key为空字符串，用于重新加载时判断策略是否修改
+	senderStrategySpecs map[string]string
+	//并发查询地址余额的数量
+	BalanceWorkers int
+	//出账备注的最大字节数，0为不限制
+	MemoMaxLength int
+	//备注是否参与签名，需要与节点的签名消息一致
+	SignNotes bool
+	//共享充值地址，转入交易按 地址:备注 匹配账户
+	SharedDepositAddresses map[string]bool
+	//手续费补充交易的等待时间，超时未到账会再次补充
+	FeesSupportPendingTimeout time.Duration
+	//是否从API或链上交易发现手续费，FixFees作为下限；关闭时总是使用FixFees
+	DynamicFees bool
+	//手续费查询接口，为空时不查询API
+	FeeAPIPath string
+	//手续费缓存时间
+	FeeCacheTTL time.Duration
+	//扫描到的链上手续费的有效时间，过期后不再使用
+	FeeObservedTTL time.Duration
+	//地址登记的间隔，失败后按倍数退避
+	InformInterval time.Duration
+	//每批登记的地址数量
+	InformBatchSize int
+	//扫描交易的签名校验：off, flag, reject
+	TxVerifyMode string
+	//接口没有返回nonce时，从发送者当前nonce向前查找的数量
+	TxVerifyNonceWindow uint64
+	//并发提取交易的数量
+	ScanWorkers int
+	//每次扫描后重扫的最近区块数量
+	RescanLastBlockCount uint64
+	//本地没有扫描记录时的起始高度，0为从最新区块开始
+	ScanStartHeight uint64
+	//区块确认数，只扫描达到确认数的区块
+	ScanConfirmations uint64
+	//轮询扫描的间隔
+	ScanPeriod time.Duration
+	//每次扫描的最大区块数量，0为不限制
+	MaxBlocksPerScan uint64
 }
 
 func NewConfig(symbol string) *WalletConfig {
@@ -50,8 +132,36 @@ func NewConfig(symbol string) *WalletConfig {
 	//币种
 	c.Symbol = symbol
 	c.CurveType = CurveType
+	c.Network = NetworkMainnet
+	c.DataDir = defaultDataDir
 	//钱包服务API
 	c.ServerAPI = ""
+	c.EndpointLimits = make(map[string]EndpointLimit)
+	c.RateLimitRetries = defaultRateLimitRetries
+	c.CircuitFailureThreshold = defaultCircuitFailureThreshold
+	c.CircuitOpenTimeout = defaultCircuitOpenTimeout
+	c.Auth = NewClientAuth()
+	c.Transport = NewTransportConfig()
+	c.DebugLog = NewDebugLogConfig()
+	c.BlockNotifyReconnect = defaultBlockNotifyReconnect
+	c.BlockNotifyIdleTimeout = defaultBlockNotifyIdleTimeout
+	c.FundingMode = FundingModeSingle
+	c.SenderStrategy = firstSender{}
+	c.AccountSenderStrategies = make(map[string]SenderStrategy)
+	c.senderStrategySpecs = make(map[string]string)
+	c.BalanceWorkers = defaultBalanceWorkers
+	c.MemoMaxLength = defaultMemoMaxLength
+	c.SharedDepositAddresses = make(map[string]bool)
+	c.FeesSupportPendingTimeout = defaultFeesSupportPendingTimeout
+	c.FeeCacheTTL = defaultFeeCacheTTL
+	c.FeeObservedTTL = defaultFeeObservedTTL
+	c.InformInterval = defaultInformInterval
+	c.InformBatchSize = defaultInformBatchSize
+	c.TxVerifyMode = TxVerifyOff
+	c.TxVerifyNonceWindow = defaultTxVerifyNonceWindow
+	c.ScanWorkers = maxExtractingSize
+	c.RescanLastBlockCount = defaultRescanLastBlockCount
+	c.ScanPeriod = defaultScanPeriod
 
 	return &c
 }
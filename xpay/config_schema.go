@@ -0,0 +1,212 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astaxie/beego/config"
+)
+
+//ConfigKind 配置项的值类型
+type ConfigKind int
+
+const (
+	ConfigString  ConfigKind = iota //字符串
+	ConfigList                      //以;分隔的字符串列表
+	ConfigURL                       //http或https地址
+	ConfigBool                      //布尔值
+	ConfigUint                      //非负整数
+	ConfigFloat                     //浮点数
+	ConfigSeconds                   //以秒为单位的非负时长
+	ConfigAmount                    //XIF数量
+)
+
+//ConfigKey 配置项定义
+type ConfigKey struct {
+	Name     string     //配置项名称
+	Kind     ConfigKind //值类型
+	Default  string     //默认值，生成模板时使用
+	Required bool       //是否必须配置，网络提供默认值时可以不配置
+	Comment  string     //说明，多行以\n分隔
+	Group    bool       //模板中在该配置项前空一行
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(d / time.Second))
+}
+
+//ConfigSchema 所有配置项，顺序即模板中的顺序
+var ConfigSchema = []ConfigKey{
+	{Name: "Network", Kind: ConfigString, Default: NetworkMainnet, Comment: "network profile: mainnet, testnet, devnet\nprovides the symbol and the defaults of ServerAPI, FixFees and the network check\naddress ext params and DataDir files of testnet and devnet include the network name, e.g. XIF-testnet-nonce"},
+	{Name: "NetworkCheckHeight", Kind: ConfigUint, Comment: "height of the block used to verify the network, empty uses the default of Network"},
+	{Name: "NetworkCheckHash", Kind: ConfigString, Comment: "expected hash of the check block, loading fails when ServerAPI serves another hash\nempty uses the default of Network, required for every network, get the hash from a trusted node"},
+	{Name: "ServerAPI", Kind: ConfigURL, Required: true, Comment: "API url, empty uses the default of Network, required for testnet and devnet", Group: true},
+	{Name: "FixFees", Kind: ConfigAmount, Required: true, Comment: "fix fees for transaction, empty uses the default of Network"},
+	{Name: "DynamicFees", Kind: ConfigBool, Default: "false", Comment: "discover fees from FeeAPIPath, or from \"Fee for ...\" transactions seen by the scanner\nFixFees is the floor, disabled means FixFees is always used"},
+	{Name: "FeeAPIPath", Kind: ConfigString, Comment: "API path of network fees such as coin/fee, response {\"fee\": \"0.01\"}, empty disables the API lookup"},
+	{Name: "FeeCacheTTL", Kind: ConfigSeconds, Default: seconds(defaultFeeCacheTTL), Comment: "seconds to cache the discovered fees"},
+	{Name: "FeeObservedTTL", Kind: ConfigSeconds, Default: seconds(defaultFeeObservedTTL), Comment: "seconds a fee seen in \"Fee for ...\" transactions stays valid, FixFees is used after it expires\ncounted from the transaction time, older fee transactions seen while catching up are ignored"},
+
+	{Name: "RateLimit", Kind: ConfigFloat, Default: "0", Comment: "requests per second of all API, 0 is unlimited", Group: true},
+	{Name: "RateBurst", Kind: ConfigUint, Default: "0", Comment: "token bucket size of rate limit"},
+	{Name: "MaxInFlight", Kind: ConfigUint, Default: "0", Comment: "max concurrent requests of all API, 0 is unlimited"},
+	{Name: "RateLimitRetries", Kind: ConfigUint, Default: strconv.Itoa(defaultRateLimitRetries), Comment: "max retries when API responds 429 Too Many Requests"},
+	{Name: "EndpointRateLimits", Kind: ConfigList, Comment: "rate limit of specified API, format: path prefix:rate:burst:max in flight, separated by ;"},
+
+	{Name: "CircuitFailureThreshold", Kind: ConfigUint, Default: strconv.Itoa(defaultCircuitFailureThreshold), Comment: "circuit breaker opens after N consecutive API failures, 0 is disabled", Group: true},
+	{Name: "CircuitOpenTimeout", Kind: ConfigSeconds, Default: seconds(defaultCircuitOpenTimeout), Comment: "seconds before a probe request is allowed after circuit breaker opens"},
+
+	{Name: "APIHeaders", Kind: ConfigList, Comment: "static headers of API request, format: name:value, separated by ;", Group: true},
+	{Name: "APIBearerToken", Kind: ConfigString, Comment: "bearer token of API request"},
+	{Name: "APISignSecret", Kind: ConfigString, Comment: "HMAC-SHA256 secret to sign API request, empty is disabled\nsigned message: METHOD\\nrequest uri\\nunix timestamp\\nform body"},
+	{Name: "APISignatureHeader", Kind: ConfigString, Default: defaultSignatureHeader, Comment: "header of request signature"},
+	{Name: "APITimestampHeader", Kind: ConfigString, Default: defaultTimestampHeader, Comment: "header of request timestamp"},
+
+	{Name: "TLSCAFile", Kind: ConfigString, Comment: "CA bundle to verify API server, PEM format", Group: true},
+	{Name: "TLSCertFile", Kind: ConfigString, Comment: "client certificate and key for mutual TLS, PEM format"},
+	{Name: "TLSKeyFile", Kind: ConfigString},
+	{Name: "TLSInsecureSkipVerify", Kind: ConfigBool, Default: "false", Comment: "skip verifying API server certificate, only for testing"},
+	{Name: "Proxy", Kind: ConfigString, Comment: "proxy of API request, support http://, https://, socks5://, empty uses HTTP_PROXY/HTTPS_PROXY"},
+	{Name: "MaxIdleConns", Kind: ConfigUint, Default: strconv.Itoa(NewTransportConfig().MaxIdleConns), Comment: "connection pool"},
+	{Name: "MaxIdleConnsPerHost", Kind: ConfigUint, Default: strconv.Itoa(NewTransportConfig().MaxIdleConnsPerHost)},
+	{Name: "MaxConnsPerHost", Kind: ConfigUint, Default: "0", Comment: "max connections per host, 0 is unlimited"},
+	{Name: "IdleConnTimeout", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().IdleConnTimeout), Comment: "timeouts in seconds, 0 is unlimited"},
+	{Name: "KeepAlive", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().KeepAlive)},
+	{Name: "DialTimeout", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().DialTimeout)},
+	{Name: "TLSHandshakeTimeout", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().TLSHandshakeTimeout)},
+	{Name: "ResponseHeaderTimeout", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().ResponseHeaderTimeout)},
+	{Name: "RequestTimeout", Kind: ConfigSeconds, Default: seconds(NewTransportConfig().RequestTimeout)},
+
+	{Name: "APIDebug", Kind: ConfigBool, Default: "false", Comment: "log API requests: method, path, status, latency, size, params and response", Group: true},
+	{Name: "DebugRedactFields", Kind: ConfigList, Comment: "extra fields redacted in API log, separated by ;\n" + strings.Join(NewDebugLogConfig().RedactFields, " and ") + " are always redacted"},
+	{Name: "DebugSampleRate", Kind: ConfigFloat, Default: strconv.FormatFloat(NewDebugLogConfig().SampleRate, 'f', -1, 64), Comment: "sample rate of successful requests in API log, 0~1, failed requests are always logged"},
+
+	{Name: "BlockNotifyURL", Kind: ConfigString, Comment: "server-sent events endpoint of new blocks, relative to ServerAPI or absolute url\nempty is disabled and scanner polls the latest block", Group: true},
+	{Name: "BlockNotifyReconnect", Kind: ConfigSeconds, Default: seconds(defaultBlockNotifyReconnect), Comment: "seconds to reconnect after subscription is disconnected, scanner polls meanwhile"},
+	{Name: "BlockNotifyIdleTimeout", Kind: ConfigSeconds, Default: seconds(defaultBlockNotifyIdleTimeout), Comment: "seconds without any data before subscription is treated as disconnected\nthe scanner also polls when no block event arrives within this time, keepalive comments do not count"},
+
+	{Name: "FundingMode", Kind: ConfigString, Default: FundingModeSingle, Comment: "how to fund a withdrawal when no single address can cover amount plus fees\nsingle: fail with insufficient balance\nconsolidate: transfer from other addresses into the richest address, then pay out from it\n  the payout is submitted after the transfers are confirmed, until then SubmitRawTransaction\n  returns error 2102 with the submitted txids, submit the same transaction again to continue\nsplit: pay out from several addresses, one transaction each\ncan be overridden per transaction with extParam {\"fundingMode\": \"split\"}", Group: true},
+
+	{Name: "SenderStrategy", Kind: ConfigString, Default: SenderStrategyFirst, Comment: "how to choose the sender address among addresses that can cover the withdrawal\nfirst: first address in address list order\nlargest: address with the largest balance\nsmallest: address with the smallest sufficient balance, spends dust first\nroundrobin: rotate among sufficient addresses\nlru: least recently used address\nhot:<address>: designated hot address only", Group: true},
+	{Name: "AccountSenderStrategies", Kind: ConfigList, Comment: "sender strategy per account, accountID=strategy, separated by ;"},
+	{Name: "BalanceWorkers", Kind: ConfigUint, Default: strconv.Itoa(defaultBalanceWorkers), Comment: "concurrent balance lookups when choosing the sender"},
+
+	{Name: "MemoMaxLength", Kind: ConfigUint, Default: strconv.Itoa(defaultMemoMaxLength), Comment: "max bytes of memo set by rawTx.SetExtParam(\"memo\", ...), printable ASCII only, 0 is unlimited", Group: true},
+	{Name: "SignNotes", Kind: ConfigBool, Default: "false", Comment: "append notes after nonce in the signed message, must match the node\ndisabled: notes are sent with the transaction but not signed, so the scanner cannot detect a tampered memo"},
+
+	{Name: "SharedDepositAddresses", Kind: ConfigList, Comment: "shared deposit addresses separated by ;, deposits to them are routed by memo\nthe scan target of a user is \"address:memo\", unmatched deposits are notified with source key xpay-unmatched-memo", Group: true},
+
+	{Name: "FeesSupportPendingTimeout", Kind: ConfigSeconds, Default: seconds(defaultFeesSupportPendingTimeout), Comment: "seconds to wait for a fees support transfer of summary before topping up the same address again\ncounted from the broadcast of the transfer, a transfer that is built but not submitted does not block the address", Group: true},
+
+	{Name: "DataDir", Kind: ConfigString, Default: defaultDataDir, Comment: "directory of local data, relative to the working directory, empty uses the default\nXIF_inform_queue.json (XIF_testnet_inform_queue.json on testnet): address inform queue\nXIF_blocks/: txids and extracted accounts of the recent 1000 blocks, used to report reverted\ntransactions per account to observers implementing xpay.ForkObserver, ForkedBlock.ContentsMissing is set for blocks not stored", Group: true},
+	{Name: "InformInterval", Kind: ConfigSeconds, Default: seconds(defaultInformInterval), Comment: "generated addresses are informed to the wallet service in background\nseconds between inform batches, failed addresses are retried with doubling backoff up to 10 minutes"},
+	{Name: "InformBatchSize", Kind: ConfigUint, Default: strconv.Itoa(defaultInformBatchSize), Comment: "max addresses informed per batch, the API has no batch endpoint so each address is one coin/inform request"},
+
+	{Name: "TxVerifyMode", Kind: ConfigString, Default: TxVerifyOff, Comment: "verify the signature of scanned transactions against the sender public key\noff: trust the API node\nflag: extract the transaction with status 0 and the verification error as reason\nreject: skip the transaction and record it in the local block, it is not verified again when the block is rescanned\nflag and reject: if the sender nonce cannot be queried or is not found in TxVerifyNonceWindow, the block is recorded as unscanned and rescanned later", Group: true},
+	{Name: "TxVerifyNonceWindow", Kind: ConfigUint, Default: strconv.Itoa(defaultTxVerifyNonceWindow), Comment: "when the API does not return the nonce, try this many nonces below the sender's current nonce\nonly a transaction whose nonce is known and whose signature fails is flagged or rejected\nthe current nonce is queried once per sender and block"},
+
+	{Name: "ScanWorkers", Kind: ConfigUint, Default: strconv.Itoa(maxExtractingSize), Comment: "concurrent transaction extraction of block scanner", Group: true},
+	{Name: "RescanLastBlockCount", Kind: ConfigUint, Default: strconv.Itoa(defaultRescanLastBlockCount), Comment: "recent blocks rescanned after each scan cycle, 0 is disabled"},
+	{Name: "ScanStartHeight", Kind: ConfigUint, Default: "0", Comment: "height to start scanning when there is no local block head, 0 starts from the latest confirmed block"},
+	{Name: "ScanConfirmations", Kind: ConfigUint, Default: "0", Comment: "confirmations required before a block is scanned, 0 scans up to the latest block"},
+	{Name: "ScanPeriod", Kind: ConfigSeconds, Default: seconds(defaultScanPeriod), Comment: "seconds between polling scans"},
+	{Name: "MaxBlocksPerScan", Kind: ConfigUint, Default: "0", Comment: "max blocks scanned per cycle, the next cycle starts right after, 0 is unlimited"},
+}
+
+//ConfigTemplate 生成带注释的ini配置模板
+func ConfigTemplate() string {
+	var b strings.Builder
+	b.WriteString("\n")
+	for i, key := range ConfigSchema {
+		if key.Group && i > 0 {
+			b.WriteString("\n")
+		}
+		if key.Comment != "" {
+			for _, line := range strings.Split(key.Comment, "\n") {
+				b.WriteString("# " + line + "\n")
+			}
+		}
+		switch key.Kind {
+		case ConfigString, ConfigList, ConfigURL, ConfigAmount:
+			b.WriteString(fmt.Sprintf("%s = %q\n", key.Name, key.Default))
+		default:
+			b.WriteString(fmt.Sprintf("%s = %s\n", key.Name, key.Default))
+		}
+	}
+	return b.String()
+}
+
+//ValidateAssetsConfig 按ConfigSchema检查配置，返回所有缺少的必填项和格式错误的配置项
+//必填项为空时使用Network的默认值，网络没有默认值才报错
+func ValidateAssetsConfig(c config.Configer) error {
+	problems := make([]string, 0)
+	profile, err := GetNetworkProfile(c.DefaultString("Network", NetworkMainnet))
+	if err != nil {
+		problems = append(problems, err.Error())
+	}
+	for _, key := range ConfigSchema {
+		value := strings.TrimSpace(c.String(key.Name))
+		if value == "" {
+			if key.Required && (profile == nil || profile.configDefault(key.Name) == "") {
+				problems = append(problems, fmt.Sprintf("%s is required", key.Name))
+			}
+			continue
+		}
+		if err := key.validate(value); err != nil {
+			problems = append(problems, fmt.Sprintf("invalid %s: %v", key.Name, err))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid asset config: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+//validate 检查配置值是否符合类型
+func (key ConfigKey) validate(value string) error {
+	switch key.Kind {
+	case ConfigURL:
+		u, err := url.Parse(value)
+		if err != nil {
+			return err
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not a http or https url", value)
+		}
+	case ConfigBool:
+		if _, err := config.ParseBool(value); err != nil {
+			return fmt.Errorf("%q is not a bool", value)
+		}
+	case ConfigUint, ConfigSeconds:
+		if v, err := strconv.Atoi(value); err != nil || v < 0 {
+			return fmt.Errorf("%q is not a non-negative integer", value)
+		}
+	case ConfigFloat:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+	case ConfigAmount:
+		if _, err := ParseAmount(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
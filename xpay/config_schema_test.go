@@ -0,0 +1,122 @@
+package xpay
+
+import (
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego/config"
+)
+
+func TestConfigSchema_Template(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	wm := NewWalletManager()
+	c, err := wm.InitAssetsConfig()
+	if err != nil {
+		t.Fatalf("InitAssetsConfig failed, err: %v", err)
+	}
+
+	c.Set("DataDir", newTempDir(t))
+
+	//主网的API和手续费使用网络默认值，校验hash必须配置
+	err = wm.LoadAssetsConfig(c)
+	if err == nil || !strings.Contains(err.Error(), "NetworkCheckHash is required") {
+		t.Fatalf("LoadAssetsConfig(mainnet template) = %v, want NetworkCheckHash required", err)
+	}
+
+	//测试网没有默认API
+	c.Set("Network", NetworkTestnet)
+	err = wm.LoadAssetsConfig(c)
+	if err == nil || !strings.Contains(err.Error(), "ServerAPI is required") || strings.Contains(err.Error(), "FixFees") {
+		t.Fatalf("LoadAssetsConfig(testnet template) = %v, want ServerAPI required", err)
+	}
+
+	//测试网必须配置校验hash
+	c.Set("ServerAPI", server.URL)
+	if err := wm.LoadAssetsConfig(c); err == nil || !strings.Contains(err.Error(), "NetworkCheckHash is required") {
+		t.Fatalf("LoadAssetsConfig(testnet template) = %v, want NetworkCheckHash required", err)
+	}
+
+	server.addBlock("genesis")
+	c.Set("NetworkCheckHeight", "100")
+	c.Set("NetworkCheckHash", "genesis")
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig(template) failed, err: %v", err)
+	}
+
+	//模板的默认值与代码的默认值一致
+	def := NewConfig(Symbol)
+	got := wm.CurrentConfig()
+	if got.FeeCacheTTL != def.FeeCacheTTL || got.FeeObservedTTL != def.FeeObservedTTL || got.RateLimitRetries != def.RateLimitRetries ||
+		got.CircuitFailureThreshold != def.CircuitFailureThreshold || got.CircuitOpenTimeout != def.CircuitOpenTimeout ||
+		got.Auth.SignatureHeader != def.Auth.SignatureHeader || got.Auth.TimestampHeader != def.Auth.TimestampHeader ||
+		*got.Transport != *def.Transport || got.DebugLog.SampleRate != def.DebugLog.SampleRate ||
+		strings.Join(got.DebugLog.RedactFields, ";") != strings.Join(def.DebugLog.RedactFields, ";") ||
+		got.BlockNotifyReconnect != def.BlockNotifyReconnect || got.BlockNotifyIdleTimeout != def.BlockNotifyIdleTimeout ||
+		got.FundingMode != def.FundingMode || got.BalanceWorkers != def.BalanceWorkers || got.MemoMaxLength != def.MemoMaxLength ||
+		got.FeesSupportPendingTimeout != def.FeesSupportPendingTimeout || got.InformInterval != def.InformInterval ||
+		got.InformBatchSize != def.InformBatchSize || got.TxVerifyMode != def.TxVerifyMode ||
+		got.TxVerifyNonceWindow != def.TxVerifyNonceWindow || got.ScanWorkers != def.ScanWorkers ||
+		got.RescanLastBlockCount != def.RescanLastBlockCount || got.ScanPeriod != def.ScanPeriod {
+		t.Errorf("template defaults differ from NewConfig")
+	}
+}
+
+func TestConfigSchema_Malformed(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	tests := []string{
+		"ServerAPI = federation.xifapi.com\nFixFees = 0.01\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01.1\n",
+		"ServerAPI = " + server.URL + "\nFixFees = -1\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01\nDynamicFees = maybe\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01\nRateLimit = fast\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01\nRateBurst = 10x\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01\nRequestTimeout = -1\n",
+		"ServerAPI = " + server.URL + "\nFixFees = 0.01\nTxVerifyNonceWindow = 1.5\n",
+	}
+	for _, test := range tests {
+		c, _ := config.NewConfigData("ini", []byte(test))
+		if err := NewWalletManager().LoadAssetsConfig(c); err == nil {
+			t.Errorf("LoadAssetsConfig(%q) should fail", test)
+		}
+	}
+
+	//所有错误一起返回
+	c, _ := config.NewConfigData("ini", []byte("Network = devnet\nRateBurst = x\nScanPeriod = y\n"))
+	err := ValidateAssetsConfig(c)
+	for _, key := range []string{"ServerAPI", "RateBurst", "ScanPeriod"} {
+		if err == nil || !strings.Contains(err.Error(), key) {
+			t.Errorf("ValidateAssetsConfig error %v does not report %s", err, key)
+		}
+	}
+}
+
+func TestConfigSchema_README(t *testing.T) {
+	data, err := ioutil.ReadFile("../README.md")
+	if err != nil {
+		t.Fatalf("read README failed, err: %v", err)
+	}
+	block := regexp.MustCompile("(?s)```ini\n(.*?)```").FindSubmatch(data)
+	if block == nil {
+		t.Fatalf("README has no ini block")
+	}
+	documented := make([]string, 0)
+	for _, m := range regexp.MustCompile(`(?m)^(\w+) = `).FindAllSubmatch(block[1], -1) {
+		documented = append(documented, string(m[1]))
+	}
+	keys := make([]string, 0, len(ConfigSchema))
+	for _, key := range ConfigSchema {
+		keys = append(keys, key.Name)
+	}
+	sort.Strings(documented)
+	sort.Strings(keys)
+	if strings.Join(documented, ",") != strings.Join(keys, ",") {
+		t.Errorf("README keys %v differ from ConfigSchema %v", documented, keys)
+	}
+}
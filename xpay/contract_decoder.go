@@ -0,0 +1,96 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+//TokenProtocol XIF平台发行资产的协议名
+const TokenProtocol = "XIF"
+
+//ContractDecoder 平台发行资产解析器
+//XIF平台的资产与主币在同一个账本上，以symbol区分，合约地址即资产的symbol
+type ContractDecoder struct {
+	*openwallet.SmartContractDecoderBase
+	wm *WalletManager
+}
+
+//NewContractDecoder 资产解析器
+func NewContractDecoder(wm *WalletManager) *ContractDecoder {
+	decoder := ContractDecoder{}
+	decoder.wm = wm
+	return &decoder
+}
+
+//GetTokenBalanceByAddress 查询地址资产余额
+func (decoder *ContractDecoder) GetTokenBalanceByAddress(contract openwallet.SmartContract, address ...string) ([]*openwallet.TokenBalance, error) {
+
+	var tokenBalanceList []*openwallet.TokenBalance
+	symbol := ContractSymbol(contract)
+
+	for _, addr := range address {
+
+		acc, err := decoder.wm.GetTokenDetails(addr, symbol)
+		if err != nil {
+			if IsBackendUnavailable(err) {
+				return nil, err
+			}
+			decoder.wm.Log.Errorf("get address[%v] token balance failed, err: %v", addr, err)
+			continue
+		}
+
+		balance, _ := decimal.NewFromString(acc.Amount)
+
+		tokenBalance := &openwallet.TokenBalance{
+			Contract: &contract,
+			Balance: &openwallet.Balance{
+				Address:          addr,
+				Symbol:           contract.Symbol,
+				Balance:          balance.String(),
+				ConfirmBalance:   balance.String(),
+				UnconfirmBalance: "0",
+			},
+		}
+
+		tokenBalanceList = append(tokenBalanceList, tokenBalance)
+	}
+
+	return tokenBalanceList, nil
+}
+
+//ContractSymbol 合约对应的链上资产symbol，优先使用合约地址
+func ContractSymbol(contract openwallet.SmartContract) string {
+	if contract.Address != "" {
+		return contract.Address
+	}
+	return contract.Token
+}
+
+//NewTokenContract 根据链上资产symbol生成合约信息
+func (wm *WalletManager) NewTokenContract(symbol string) openwallet.SmartContract {
+	contract := openwallet.SmartContract{
+		Symbol:   wm.Symbol(),
+		Address:  symbol,
+		Token:    symbol,
+		Protocol: TokenProtocol,
+		Name:     symbol,
+		Decimals: uint64(wm.Decimal()),
+	}
+	contract.ContractID = openwallet.GenContractID(contract.Symbol, contract.Address)
+	return contract
+}
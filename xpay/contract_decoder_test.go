@@ -0,0 +1,96 @@
+package xpay
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestContractDecoder_GetTokenBalanceByAddress(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setAccount("addr1", Symbol, "1", 0)
+	server.setAccount("addr1", "USDX", "12.5", 0)
+
+	wm := newMockWalletManager(t, server, "")
+	contract := wm.NewTokenContract("USDX")
+	balances, err := wm.GetSmartContractDecoder().GetTokenBalanceByAddress(contract, "addr1", "addr2")
+	if err != nil {
+		t.Fatalf("GetTokenBalanceByAddress failed, err: %v", err)
+	}
+	if len(balances) != 1 {
+		t.Fatalf("balances = %d, want 1", len(balances))
+	}
+	if balances[0].Balance.Balance != "12.5" || balances[0].Contract.Address != "USDX" {
+		t.Errorf("unexpected balance: %+v", balances[0].Balance)
+	}
+}
+
+func TestTransactionDecoder_CreateTokenRawTransaction(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	//addr1资产足够但主币不够手续费
+	server.setAccount("addr1", Symbol, "0.005", 0)
+	server.setAccount("addr1", "USDX", "100", 0)
+	server.setAccount("addr2", Symbol, "1", 3)
+	server.setAccount("addr2", "USDX", "50", 0)
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", "addr1", "addr2")
+	coin := openwallet.Coin{
+		Symbol:     Symbol,
+		IsContract: true,
+		Contract:   wm.NewTokenContract("USDX"),
+	}
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:    coin,
+		Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:      map[string]string{testAddress("dest"): "40"},
+	}
+	if err := wm.GetTransactionDecoder().CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	txHex, _ := hex.DecodeString(rawTx.RawHex)
+	var tx RawTransaction
+	json.Unmarshal(txHex, &tx)
+	if tx.Sender != "addr2" || tx.Symbol != "USDX" || tx.Amount != "40" || tx.Nonce != 4 {
+		t.Errorf("unexpected raw transaction: %+v", tx)
+	}
+
+	rawTx = &openwallet.RawTransaction{
+		Coin:    coin,
+		Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:      map[string]string{testAddress("dest"): "60"},
+	}
+	err := wm.GetTransactionDecoder().CreateRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientTokenBalanceOfAddress {
+		t.Errorf("err = %v, want insufficient token balance", err)
+	}
+}
+
+func TestBlockScanner_ExtractTokenTransaction(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	height := server.addBlock("h100")
+	server.addTransaction("tx1", height, "sender", "receiver", "USDX", "3", "")
+
+	wm := newMockWalletManager(t, server, "")
+	bs := wm.Blockscanner.(*BlockScanner)
+	result := bs.ExtractTransaction("tx1", func(target openwallet.ScanTarget) (string, bool) {
+		return "acc1", target.Address == "receiver"
+	})
+	if !result.Success {
+		t.Fatalf("extract transaction failed")
+	}
+	data := result.extractData["acc1"]
+	if data == nil || len(data.TxOutputs) != 1 {
+		t.Fatalf("token receipt not extracted")
+	}
+	coin := data.Transaction.Coin
+	if !coin.IsContract || coin.Contract.Address != "USDX" || coin.ContractID != openwallet.GenContractID(Symbol, "USDX") {
+		t.Errorf("unexpected coin: %+v", coin)
+	}
+}
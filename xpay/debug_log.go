@@ -0,0 +1,142 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/imroc/req"
+)
+
+const redactedValue = "***"
+
+//DebugLogConfig 调试日志配置
+type DebugLogConfig struct {
+	RedactFields []string //需要脱敏的字段名，不区分大小写
+	SampleRate   float64  //成功请求的采样率，0~1，失败请求总是输出
+}
+
+//NewDebugLogConfig 默认调试日志配置，私钥和签名默认脱敏
+func NewDebugLogConfig() *DebugLogConfig {
+	return &DebugLogConfig{
+		RedactFields: []string{"privatekey", "signature"},
+		SampleRate:   1,
+	}
+}
+
+//redacted 字段是否需要脱敏
+func (dc *DebugLogConfig) redacted(key string) bool {
+	for _, f := range dc.RedactFields {
+		if strings.EqualFold(f, key) {
+			return true
+		}
+	}
+	return false
+}
+
+//sampled 本次成功请求是否输出
+func (dc *DebugLogConfig) sampled() bool {
+	if dc.SampleRate >= 1 {
+		return true
+	}
+	return rand.Float64() < dc.SampleRate
+}
+
+//redactParam 脱敏请求参数
+func (dc *DebugLogConfig) redactParam(param interface{}) string {
+	p, ok := param.(req.Param)
+	if !ok || len(p) == 0 {
+		return "{}"
+	}
+	obj := make(map[string]interface{}, len(p))
+	for k, v := range p {
+		if dc.redacted(k) {
+			obj[k] = redactedValue
+		} else {
+			obj[k] = v
+		}
+	}
+	b, _ := json.Marshal(obj)
+	return string(b)
+}
+
+//redactJSON 脱敏响应内容，非JSON内容只输出长度
+func (dc *DebugLogConfig) redactJSON(data []byte) string {
+	if len(data) == 0 {
+		return ""
+	}
+	var obj interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return "<non-json body>"
+	}
+	b, _ := json.Marshal(dc.redactValue(obj))
+	return string(b)
+}
+
+func (dc *DebugLogConfig) redactValue(v interface{}) interface{} {
+	switch vv := v.(type) {
+	case map[string]interface{}:
+		for k, item := range vv {
+			if dc.redacted(k) {
+				vv[k] = redactedValue
+			} else {
+				vv[k] = dc.redactValue(item)
+			}
+		}
+		return vv
+	case []interface{}:
+		for i, item := range vv {
+			vv[i] = dc.redactValue(item)
+		}
+		return vv
+	}
+	return v
+}
+
+//logRequest 输出结构化的请求日志，不包含请求头
+func (c *Client) logRequest(method, path string, param interface{}, r *req.Resp, err error, latency time.Duration) {
+
+	dc := c.debugLog
+	if dc == nil {
+		dc = NewDebugLogConfig()
+	}
+
+	if err == nil && !dc.sampled() {
+		return
+	}
+
+	var (
+		status int
+		body   []byte
+	)
+	if r != nil && r.Response() != nil {
+		status = r.Response().StatusCode
+		body = r.Bytes()
+	}
+
+	if err != nil {
+		log.Std.Info("API request method=%s path=%s status=%d latency=%v bytes=%d params=%s error=%v",
+			method, path, status, latency, len(body), dc.redactParam(param), err)
+		return
+	}
+
+	log.Std.Info("API request method=%s path=%s status=%d latency=%v bytes=%d params=%s response=%s",
+		method, path, status, latency, len(body), dc.redactParam(param), dc.redactJSON(body))
+}
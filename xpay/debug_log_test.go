@@ -0,0 +1,56 @@
+package xpay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/log"
+	"github.com/imroc/req"
+)
+
+func TestDebugLogConfig_Redact(t *testing.T) {
+	dc := NewDebugLogConfig()
+
+	params := dc.redactParam(req.Param{"sender": "02ab", "privatekey": "672c6012"})
+	if strings.Contains(params, "672c6012") || !strings.Contains(params, "02ab") {
+		t.Errorf("unexpected params: %s", params)
+	}
+
+	body := dc.redactJSON([]byte(`{"transaction":{"key":"bfeb","Signature":"3044"},"list":[{"privateKey":"672c"}]}`))
+	if strings.Contains(body, "3044") || strings.Contains(body, "672c") || !strings.Contains(body, "bfeb") {
+		t.Errorf("unexpected body: %s", body)
+	}
+}
+
+func TestClient_DebugLog(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+
+	path := filepath.Join(newTempDir(t), "api.log")
+	if err := log.Std.SetLogger("file", fmt.Sprintf(`{"filename":%q}`, path)); err != nil {
+		t.Fatalf("SetLogger failed, err: %v", err)
+	}
+	defer log.Std.DelLogger("file")
+
+	//配置的字段追加在默认字段之后
+	wm := newMockWalletManager(t, server, "APIDebug = true\nDebugRedactFields = apikey\n")
+	if _, err := wm.client().call("GET", "coin/blocks/latest", req.Param{"privatekey": "secret-key", "apikey": "secret-api-key", "symbol": "visible"}); err != nil {
+		t.Fatalf("call failed, err: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read log failed, err: %v", err)
+	}
+	line := string(data)
+	if !strings.Contains(line, "path=coin/blocks/latest") || !strings.Contains(line, "visible") || !strings.Contains(line, "h100") {
+		t.Errorf("API request is not logged: %s", line)
+	}
+	if strings.Contains(line, "secret-key") || strings.Contains(line, "secret-api-key") {
+		t.Errorf("redacted fields are logged: %s", line)
+	}
+}
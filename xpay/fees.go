@@ -0,0 +1,162 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FeeSourceFixed    = "fixed"    //配置的FixFees
+	FeeSourceAPI      = "api"      //API查询
+	FeeSourceObserved = "observed" //扫描到的手续费交易
+)
+
+const (
+	defaultFeeCacheTTL = 60 * time.Second
+	//defaultFeeObservedTTL 链上手续费交易的有效时间
+	defaultFeeObservedTTL = time.Hour
+	//feeMemoPrefix 链上手续费交易的备注前缀
+	feeMemoPrefix = "Fee for "
+)
+
+//FeeStatus 当前手续费
+type FeeStatus struct {
+	Fees      decimal.Decimal //每笔交易手续费
+	Source    string          //手续费来源
+	UpdatedAt time.Time       //更新时间
+}
+
+//feeOracle 手续费发现，API优先，其次为扫描到的手续费交易，FixFees为下限
+type feeOracle struct {
+	mu         sync.Mutex
+	current    FeeStatus
+	config     *WalletConfig //发现current时的配置，重新加载配置后缓存失效
+	observed   decimal.Decimal
+	observedAt time.Time
+}
+
+//Fees 每笔交易的手续费
+func (wm *WalletManager) Fees() decimal.Decimal {
+	return wm.FeeStatus().Fees
+}
+
+//FeeStatus 当前手续费及来源，未开启DynamicFees时总是返回FixFees
+func (wm *WalletManager) FeeStatus() FeeStatus {
+
+	state := wm.snapshot()
+	cfg := state.config
+	if !cfg.DynamicFees {
+		return FeeStatus{Fees: cfg.FixFees, Source: FeeSourceFixed}
+	}
+
+	oracle := wm.fees
+	oracle.mu.Lock()
+	if oracle.config == cfg && !oracle.current.UpdatedAt.IsZero() && time.Since(oracle.current.UpdatedAt) < cfg.FeeCacheTTL {
+		defer oracle.mu.Unlock()
+		return oracle.current
+	}
+	oracle.mu.Unlock()
+
+	//查询API时不持有锁，并发的查询各自请求，以最后完成的为准
+	apiFees, apiErr := wm.queryNetworkFees(state)
+
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+
+	fees, source := discoverFees(oracle, cfg, apiFees, apiErr)
+
+	//FixFees为下限
+	if fees.LessThan(cfg.FixFees) {
+		fees, source = cfg.FixFees, FeeSourceFixed
+	}
+
+	if !oracle.current.UpdatedAt.IsZero() && !fees.Equal(oracle.current.Fees) {
+		wm.Log.Std.Warning("network fees changed from %s to %s, source: %s", oracle.current.Fees.String(), fees.String(), source)
+	}
+
+	oracle.current = FeeStatus{Fees: fees, Source: source, UpdatedAt: time.Now()}
+	oracle.config = cfg
+	return oracle.current
+}
+
+//queryNetworkFees 从API查询手续费，没有配置FeeAPIPath时返回错误
+func (wm *WalletManager) queryNetworkFees(state *walletState) (decimal.Decimal, error) {
+	if state.config.FeeAPIPath == "" || state.client == nil {
+		return decimal.Zero, fmt.Errorf("FeeAPIPath is not configured")
+	}
+	fees, err := wm.networkFees(state)
+	if err != nil {
+		wm.Log.Std.Debug("get network fees failed, err: %v", err)
+	}
+	return fees, err
+}
+
+//discoverFees 选择手续费来源，API优先，其次为未过期的链上手续费交易，调用前需持有锁
+func discoverFees(oracle *feeOracle, cfg *WalletConfig, apiFees decimal.Decimal, apiErr error) (decimal.Decimal, string) {
+
+	if apiErr == nil {
+		return apiFees, FeeSourceAPI
+	}
+
+	if !oracle.observedAt.IsZero() && time.Since(oracle.observedAt) < cfg.FeeObservedTTL {
+		return oracle.observed, FeeSourceObserved
+	}
+
+	return cfg.FixFees, FeeSourceFixed
+}
+
+//observeFeeTransaction 扫描到手续费交易时记录手续费，按交易时间计算是否过期
+//追赶旧区块时扫描到的历史手续费，以及比已记录的更早的手续费交易都忽略
+func (wm *WalletManager) observeFeeTransaction(tx *Transaction) {
+
+	if !wm.CurrentConfig().DynamicFees || tx.Symbol != wm.Symbol() || !strings.HasPrefix(tx.Memo, feeMemoPrefix) {
+		return
+	}
+
+	observedAt := tx.Timestamp
+	if observedAt.IsZero() || time.Since(observedAt) >= wm.CurrentConfig().FeeObservedTTL {
+		return
+	}
+	//节点时钟超前时不能超过当前时间
+	if now := time.Now(); observedAt.After(now) {
+		observedAt = now
+	}
+
+	amount, err := ParseTransferAmount(tx.Amount)
+	if err != nil {
+		return
+	}
+	fees := amount.Decimal()
+
+	oracle := wm.fees
+	oracle.mu.Lock()
+	defer oracle.mu.Unlock()
+
+	if observedAt.Before(oracle.observedAt) {
+		return
+	}
+	if !oracle.observed.Equal(fees) {
+		wm.Log.Std.Debug("observed network fees %s in transaction %s", fees.String(), tx.Hash)
+	}
+	oracle.observed = fees
+	oracle.observedAt = observedAt
+}
@@ -0,0 +1,119 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"sort"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/common"
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const defaultFeesSupportPendingTimeout = 10 * time.Minute
+
+//feesSupportKey 地址扩展参数，记录手续费补充交易的广播时间
+func (wm *WalletManager) feesSupportKey() string {
+	return wm.localKey("feesSupport")
+}
+
+//feesSupportAmount 每个地址补充的手续费数量，优先使用固定数量，否则为手续费乘以倍率
+func (decoder *TransactionDecoder) feesSupportAmount(feesAccount *openwallet.FeesSupportAccount) decimal.Decimal {
+	if fixAmount, err := decimal.NewFromString(feesAccount.FixSupportAmount); err == nil && fixAmount.GreaterThan(decimal.Zero) {
+		return fixAmount
+	}
+	scale, err := decimal.NewFromString(feesAccount.FeesSupportScale)
+	if err != nil || scale.LessThanOrEqual(decimal.Zero) {
+		scale = decimal.New(1, 0)
+	}
+	return decoder.wm.Fees().Mul(scale)
+}
+
+//feesSupportPending 地址是否有已广播、未确认的手续费补充交易
+func (decoder *TransactionDecoder) feesSupportPending(wrapper openwallet.WalletDAI, address string) bool {
+	val, err := wrapper.GetAddressExtParam(address, decoder.wm.feesSupportKey())
+	if err != nil || val == nil {
+		return false
+	}
+	created := common.NewString(val).Int64()
+	if created <= 0 {
+		return false
+	}
+	return time.Since(time.Unix(created, 0)) < decoder.wm.CurrentConfig().FeesSupportPendingTimeout
+}
+
+//setFeesSupportPending 记录或清除地址的手续费补充交易
+func (decoder *TransactionDecoder) setFeesSupportPending(wrapper openwallet.WalletDAI, address string, created int64) {
+	if err := wrapper.SetAddressExtParam(address, decoder.wm.feesSupportKey(), created); err != nil {
+		decoder.wm.Log.Errorf("WalletDAI SetAddressExtParam failed, err: %v", err)
+	}
+}
+
+//createFeesSupportRawTransaction 从手续费支持账户向需要补充的地址转账，多个地址合并为一笔批量交易
+func (decoder *TransactionDecoder) createFeesSupportRawTransaction(
+	wrapper openwallet.WalletDAI,
+	sumRawTx *openwallet.SummaryRawTransaction,
+	supportTo map[string]string) *openwallet.RawTransactionWithError {
+
+	feesAccount, err := wrapper.GetAssetsAccountInfo(sumRawTx.FeesSupportAccount.AccountID)
+	if err != nil {
+		feesAccount = &openwallet.AssetsAccount{AccountID: sumRawTx.FeesSupportAccount.AccountID}
+	}
+
+	addresses := make([]string, 0, len(supportTo))
+	for address := range supportTo {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+
+	rawTx := &openwallet.RawTransaction{
+		Coin:     openwallet.Coin{Symbol: decoder.wm.Symbol()},
+		Account:  feesAccount,
+		To:       supportTo,
+		Required: 1,
+	}
+	rawTx.SetExtParam("feesSupport", addresses)
+
+	if createErr := decoder.CreateRawTransaction(wrapper, rawTx); createErr != nil {
+		return &openwallet.RawTransactionWithError{
+			RawTx: rawTx,
+			Error: summaryError(createErr),
+		}
+	}
+
+	//广播成功后才记录补充交易，见SubmitRawTransaction
+	for _, address := range addresses {
+		decoder.wm.Log.Infof("fees support %s to address[%s]", supportTo[address], address)
+	}
+
+	return &openwallet.RawTransactionWithError{
+		RawTx: rawTx,
+		Error: nil,
+	}
+}
+
+//feesSupportAddresses 手续费补充交易单补充的地址，不是补充交易单时为空
+func feesSupportAddresses(rawTx *openwallet.RawTransaction) map[string]bool {
+	addresses := make(map[string]bool)
+	if len(rawTx.ExtParam) == 0 {
+		return addresses
+	}
+	for _, address := range rawTx.GetExtParam().Get("feesSupport").Array() {
+		addresses[address.String()] = true
+	}
+	return addresses
+}
@@ -0,0 +1,98 @@
+package xpay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestWalletManager_DynamicFees(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setFee("0.05")
+
+	//未开启时总是使用FixFees
+	wm := newMockWalletManager(t, server, "FeeAPIPath = coin/fee\n")
+	if status := wm.FeeStatus(); status.Fees.String() != "0.01" || status.Source != FeeSourceFixed {
+		t.Errorf("static fees = %s (%s), want 0.01", status.Fees, status.Source)
+	}
+
+	wm = newMockWalletManager(t, server, "DynamicFees = true\nFeeAPIPath = coin/fee\nFeeCacheTTL = 0\n")
+	feeRate, _, _ := wm.GetTransactionDecoder().GetRawTransactionFeeRate()
+	if status := wm.FeeStatus(); feeRate != "0.05" || status.Source != FeeSourceAPI {
+		t.Errorf("api fees = %s (%s), want 0.05", feeRate, status.Source)
+	}
+
+	//FixFees为下限
+	server.setFee("0.001")
+	if status := wm.FeeStatus(); status.Fees.String() != "0.01" || status.Source != FeeSourceFixed {
+		t.Errorf("floor fees = %s (%s), want 0.01", status.Fees, status.Source)
+	}
+
+	//API不可用时使用扫描到的手续费
+	server.setFee("")
+	height := server.addBlock("h100")
+	server.addTransaction("fee1", height, "sender", "node", Symbol, "0.03", "Fee for tx1")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.ExtractTransaction("fee1", func(target openwallet.ScanTarget) (string, bool) {
+		return "", false
+	})
+	if status := wm.FeeStatus(); status.Fees.String() != "0.03" || status.Source != FeeSourceObserved {
+		t.Errorf("observed fees = %s (%s), want 0.03", status.Fees, status.Source)
+	}
+
+	//扫描到的手续费过期后使用FixFees
+	wm.fees.mu.Lock()
+	wm.fees.observedAt = time.Now().Add(-wm.CurrentConfig().FeeObservedTTL)
+	wm.fees.mu.Unlock()
+	if status := wm.FeeStatus(); status.Fees.String() != "0.01" || status.Source != FeeSourceFixed {
+		t.Errorf("expired observed fees = %s (%s), want 0.01", status.Fees, status.Source)
+	}
+}
+
+func TestWalletManager_FeesCache(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setFee("0.05")
+
+	wm := newMockWalletManager(t, server, "DynamicFees = true\nFeeAPIPath = coin/fee\nFeeCacheTTL = 60\n")
+	if fees := wm.Fees(); fees.String() != "0.05" {
+		t.Fatalf("fees = %s, want 0.05", fees)
+	}
+	server.setFee("0.08")
+	if fees := wm.Fees(); fees.String() != "0.05" {
+		t.Errorf("cached fees = %s, want 0.05", fees)
+	}
+}
+
+func TestWalletManager_ObservedFeesTime(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	wm := newMockWalletManager(t, server, "DynamicFees = true\nFeeCacheTTL = 0\nFeeObservedTTL = 3600\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	height := server.addBlock("h100")
+	observe := func(txid, fees string, created time.Time) {
+		tx := server.addTransaction(txid, height, "sender", "node", Symbol, fees, "Fee for "+txid)
+		server.mu.Lock()
+		tx["created"] = created.UTC().Format(TimeLayout)
+		server.mu.Unlock()
+		bs.ExtractTransaction(txid, func(target openwallet.ScanTarget) (string, bool) {
+			return "", false
+		})
+	}
+
+	//追赶旧区块时的历史手续费不提高下限
+	observe("old", "0.09", time.Now().Add(-2*time.Hour))
+	if status := wm.FeeStatus(); status.Fees.String() != "0.01" || status.Source != FeeSourceFixed {
+		t.Errorf("historical fees = %s (%s), want 0.01", status.Fees, status.Source)
+	}
+
+	//比已记录的更早的手续费交易不覆盖
+	observe("newer", "0.03", time.Now().Add(-time.Minute))
+	observe("older", "0.05", time.Now().Add(-30*time.Minute))
+	if status := wm.FeeStatus(); status.Fees.String() != "0.03" || status.Source != FeeSourceObserved {
+		t.Errorf("observed fees = %s (%s), want 0.03", status.Fees, status.Source)
+	}
+}
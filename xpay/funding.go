@@ -0,0 +1,273 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	FundingModeSingle      = "single"      //只使用单个地址出账
+	FundingModeConsolidate = "consolidate" //先从其他地址归集到出账地址，再出账
+	FundingModeSplit       = "split"       //由多个地址分别向接收者出账
+)
+
+const (
+	FundingStepConsolidate = "consolidate"
+	FundingStepPayout      = "payout"
+)
+
+//ErrSubmitRawTransactionPending 归集交易已广播但还未确认，出账交易没有广播
+//已广播的txid记录在交易单扩展参数txids，归集交易确认后再次提交同一个交易单广播出账交易
+const ErrSubmitRawTransactionPending = 2102
+
+//FundingStep 资金计划中的一笔交易
+type FundingStep struct {
+	Type   string `json:"type"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	Nonce  uint64 `json:"nonce"`
+	Fees   string `json:"fees"`
+}
+
+//FundingPlan 多地址出账计划，签名前通过交易单扩展参数fundingPlan返回
+type FundingPlan struct {
+	Mode      string         `json:"mode"`
+	Steps     []*FundingStep `json:"steps"`
+	TotalFees string         `json:"totalFees"`
+}
+
+//fundingSource 可用于出账的地址
+type fundingSource struct {
+	account   *XIFAccount
+	available decimal.Decimal //扣除手续费后可转出的数量
+}
+
+//fundingMode 交易单的出账模式，扩展参数fundingMode优先于配置
+func (decoder *TransactionDecoder) fundingMode(rawTx *openwallet.RawTransaction) (string, error) {
+	mode := decoder.wm.CurrentConfig().FundingMode
+	if len(rawTx.ExtParam) > 0 {
+		if m := rawTx.GetExtParam().Get("fundingMode").String(); m != "" {
+			mode = m
+		}
+	}
+	switch mode {
+	case "", FundingModeSingle:
+		return FundingModeSingle, nil
+	case FundingModeConsolidate, FundingModeSplit:
+		return mode, nil
+	}
+	return "", fmt.Errorf("unsupported funding mode: %s", mode)
+}
+
+//newFundingPlan 根据各地址可用数量生成出账计划，可用数量不足时返回nil
+func newFundingPlan(mode string, sources []*fundingSource, destination string, amount, fees decimal.Decimal) *FundingPlan {
+
+	//可用数量多的地址优先，减少交易笔数
+	sorted := make([]*fundingSource, len(sources))
+	copy(sorted, sources)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].available.GreaterThan(sorted[j].available)
+	})
+
+	plan := &FundingPlan{Mode: mode}
+	remaining := amount
+
+	switch mode {
+	case FundingModeConsolidate:
+		if len(sorted) == 0 {
+			return nil
+		}
+		//可用最多的地址作为出账地址，其余地址只归集不足的部分
+		hot := sorted[0]
+		remaining = remaining.Sub(hot.available)
+		for _, src := range sorted[1:] {
+			if remaining.LessThanOrEqual(decimal.Zero) {
+				break
+			}
+			send := decimal.Min(src.available, remaining)
+			plan.Steps = append(plan.Steps, &FundingStep{
+				Type:   FundingStepConsolidate,
+				From:   src.account.Publickey,
+				To:     hot.account.Publickey,
+				Amount: send.String(),
+				Fees:   fees.String(),
+			})
+			remaining = remaining.Sub(send)
+		}
+		plan.Steps = append(plan.Steps, &FundingStep{
+			Type:   FundingStepPayout,
+			From:   hot.account.Publickey,
+			To:     destination,
+			Amount: amount.String(),
+			Fees:   fees.String(),
+		})
+	case FundingModeSplit:
+		for _, src := range sorted {
+			if remaining.LessThanOrEqual(decimal.Zero) {
+				break
+			}
+			send := decimal.Min(src.available, remaining)
+			if send.LessThanOrEqual(decimal.Zero) {
+				continue
+			}
+			plan.Steps = append(plan.Steps, &FundingStep{
+				Type:   FundingStepPayout,
+				From:   src.account.Publickey,
+				To:     destination,
+				Amount: send.String(),
+				Fees:   fees.String(),
+			})
+			remaining = remaining.Sub(send)
+		}
+	default:
+		return nil
+	}
+
+	if remaining.GreaterThan(decimal.Zero) {
+		return nil
+	}
+
+	plan.TotalFees = fees.Mul(decimal.New(int64(len(plan.Steps)), 0)).String()
+	return plan
+}
+
+//createFundingRawTransaction 按出账计划创建交易单，同一发送者的nonce依次递增
+func (decoder *TransactionDecoder) createFundingRawTransaction(
+	wrapper openwallet.WalletDAI,
+	rawTx *openwallet.RawTransaction,
+	plan *FundingPlan,
+	sources []*fundingSource) error {
+
+	var (
+		accountTotalSent = decimal.Zero
+		txFrom           = make([]string, 0)
+		txTo             = make([]string, 0)
+		keySignList      = make([]*openwallet.KeySignature, 0)
+		txs              = make([]*RawTransaction, 0)
+		nonces           = make(map[string]uint64)
+	)
+
+	decimals := decoder.wm.Decimal()
+
+	memo, err := decoder.memo(rawTx)
+	if err != nil {
+		return err
+	}
+
+	accounts := make(map[string]*XIFAccount, len(sources))
+	for _, src := range sources {
+		accounts[src.account.Publickey] = src.account
+	}
+
+	for _, step := range plan.Steps {
+
+		addr, err := wrapper.GetAddress(step.From)
+		if err != nil {
+			return err
+		}
+
+		nonce, ok := nonces[step.From]
+		if !ok {
+			nonce = decoder.wm.GetAddressNonce(wrapper, accounts[step.From])
+		}
+		nonce = nonce + 1
+		nonces[step.From] = nonce
+		step.Nonce = nonce
+
+		//归集交易在账户内部，不计入账户的实际转账数量
+		if step.Type == FundingStepPayout {
+			accountTotalSentAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", step.To)
+			if findErr != nil || len(accountTotalSentAddresses) == 0 {
+				amountDec, _ := decimal.NewFromString(step.Amount)
+				accountTotalSent = accountTotalSent.Add(amountDec)
+			}
+		}
+
+		txFrom = append(txFrom, fmt.Sprintf("%s:%s", step.From, step.Amount))
+		txTo = append(txTo, fmt.Sprintf("%s:%s", step.To, step.Amount))
+
+		tx := &RawTransaction{
+			Sender:    step.From,
+			Recipient: step.To,
+			Symbol:    coinSymbol(rawTx.Coin),
+			Amount:    step.Amount,
+			Nonce:     nonce,
+		}
+		//备注只用于向接收者出账的交易
+		if step.Type == FundingStepPayout {
+			tx.Notes = memo
+		}
+		txs = append(txs, tx)
+
+		keySignList = append(keySignList, &openwallet.KeySignature{
+			EccType: decoder.wm.CurrentConfig().CurveType,
+			Nonce:   strconv.FormatUint(nonce, 10),
+			Address: addr,
+			Message: hex.EncodeToString(tx.Hash(decoder.wm.CurrentConfig().SignNotes)),
+		})
+
+		decoder.wm.Log.Infof("funding plan %s: %s -> %s, amount: %s, nonce: %d", step.Type, step.From, step.To, step.Amount, nonce)
+	}
+
+	rawTx.RawHex = EncodeRawTransactions(txs)
+
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	}
+
+	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
+
+	rawTx.Signatures[rawTx.Account.AccountID] = keySignList
+	rawTx.FeeRate = ""
+	rawTx.Fees = plan.TotalFees
+	rawTx.IsBuilt = true
+	rawTx.TxAmount = accountTotalSent.StringFixed(decimals)
+	rawTx.TxFrom = txFrom
+	rawTx.TxTo = txTo
+
+	return rawTx.SetExtParam("fundingPlan", plan)
+}
+
+//unconfirmedConsolidations 交易单中第index笔为出账交易时，转入其出账地址但还未确认的归集交易
+func (decoder *TransactionDecoder) unconfirmedConsolidations(rawTx *openwallet.RawTransaction, index int, txids []string) []string {
+	if len(rawTx.ExtParam) == 0 {
+		return nil
+	}
+	steps := rawTx.GetExtParam().Get("fundingPlan.steps").Array()
+	if index >= len(steps) || steps[index].Get("type").String() != FundingStepPayout {
+		return nil
+	}
+	from := steps[index].Get("from").String()
+	unconfirmed := make([]string, 0)
+	for i := 0; i < index && i < len(txids); i++ {
+		if steps[i].Get("type").String() != FundingStepConsolidate || steps[i].Get("to").String() != from {
+			continue
+		}
+		tx, err := decoder.wm.GetTransaction(txids[i])
+		if err != nil || tx.BlockHeight == 0 {
+			unconfirmed = append(unconfirmed, txids[i])
+		}
+	}
+	return unconfirmed
+}
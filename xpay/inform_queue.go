@@ -0,0 +1,354 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInformInterval   = 10 * time.Second
+	defaultInformBatchSize  = 50
+	defaultInformMaxBackoff = 10 * time.Minute
+	defaultInformSaveDelay  = time.Second
+)
+
+//InformRecord 等待向钱包服务登记的地址
+type InformRecord struct {
+	Address   string    `json:"address"`
+	Symbol    string    `json:"symbol"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"lastError,omitempty"`
+	NextRetry time.Time `json:"nextRetry"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+//informQueue 地址登记队列，地址生成时入队，后台按批次登记，失败后退避重试
+//加载配置后队列持久化到DataDir，重启后继续登记；入队只标记修改，延迟合并写入，批量生成地址时不会每个地址重写一次文件
+type informQueue struct {
+	wm        *WalletManager
+	mu        sync.Mutex
+	flushMu   sync.Mutex
+	path      string
+	pending   map[string]*InformRecord
+	dirty     bool        //队列有未写入文件的修改
+	saveTimer *time.Timer //延迟写入的定时器
+	wake      chan struct{}
+	quit      chan struct{}
+	running   bool
+}
+
+func newInformQueue(wm *WalletManager) *informQueue {
+	return &informQueue{
+		wm:      wm,
+		pending: make(map[string]*InformRecord),
+		wake:    make(chan struct{}, 1),
+	}
+}
+
+func informKey(address, symbol string) string {
+	return symbol + ":" + address
+}
+
+//readInformQueue 读取持久化的队列，不修改当前队列，dataDir为空时路径为空
+func readInformQueue(dataDir, name string) (string, []*InformRecord, error) {
+	if dataDir == "" {
+		return "", nil, nil
+	}
+	path := filepath.Join(dataDir, name)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return path, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	records := make([]*InformRecord, 0)
+	if err := json.Unmarshal(data, &records); err != nil {
+		return "", nil, err
+	}
+	return path, records, nil
+}
+
+//use 切换到readInformQueue读取的队列文件，合并其中的地址，path为空时只保存在内存，重启后未登记的地址会丢失
+func (q *informQueue) use(path string, records []*InformRecord) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.path = path
+	if path == "" {
+		q.wm.Log.Std.Warning("inform queue is kept in memory only, unregistered addresses are lost on restart")
+		return
+	}
+	for _, r := range records {
+		if _, exist := q.pending[informKey(r.Address, r.Symbol)]; !exist {
+			q.pending[informKey(r.Address, r.Symbol)] = r
+		}
+	}
+	//队列文件不存在或内存中有文件没有的地址时写入
+	if records == nil || len(q.pending) != len(records) {
+		q.markDirtyLocked()
+	}
+	if len(q.pending) > 0 {
+		q.startLocked()
+	}
+}
+
+//saveLocked 写入队列文件，先写临时文件再替换，调用前需持有锁
+func (q *informQueue) saveLocked() error {
+	if q.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(q.path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(q.listLocked())
+	if err != nil {
+		return err
+	}
+	tmp := q.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, q.path); err != nil {
+		return err
+	}
+	q.dirty = false
+	return nil
+}
+
+//markDirtyLocked 标记队列已修改，defaultInformSaveDelay后合并写入，调用前需持有锁
+func (q *informQueue) markDirtyLocked() {
+	if q.path == "" {
+		return
+	}
+	q.dirty = true
+	if q.saveTimer == nil {
+		q.saveTimer = time.AfterFunc(defaultInformSaveDelay, q.saveDirty)
+	}
+}
+
+//saveDirty 写入未保存的修改
+func (q *informQueue) saveDirty() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.saveDirtyLocked()
+}
+
+//saveDirtyLocked 写入未保存的修改，调用前需持有锁
+func (q *informQueue) saveDirtyLocked() {
+	if q.saveTimer != nil {
+		q.saveTimer.Stop()
+		q.saveTimer = nil
+	}
+	if !q.dirty {
+		return
+	}
+	if err := q.saveLocked(); err != nil {
+		q.wm.Log.Errorf("save inform queue failed, err: %v", err)
+	}
+}
+
+//listLocked 按创建时间排序的待登记地址，调用前需持有锁
+func (q *informQueue) listLocked() []*InformRecord {
+	records := make([]*InformRecord, 0, len(q.pending))
+	for _, r := range q.pending {
+		records = append(records, r)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].CreatedAt.Equal(records[j].CreatedAt) {
+			return records[i].Address < records[j].Address
+		}
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records
+}
+
+//add 地址入队，已在队列中的地址不重复添加
+func (q *informQueue) add(address, symbol string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	key := informKey(address, symbol)
+	if _, exist := q.pending[key]; exist {
+		return nil
+	}
+	now := time.Now()
+	q.pending[key] = &InformRecord{Address: address, Symbol: symbol, NextRetry: now, CreatedAt: now}
+	q.markDirtyLocked()
+	q.startLocked()
+	q.notify()
+	return nil
+}
+
+func (q *informQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+//startLocked 启动后台登记，调用前需持有锁
+func (q *informQueue) startLocked() {
+	if q.running {
+		return
+	}
+	q.running = true
+	q.quit = make(chan struct{})
+	go q.run(q.quit)
+}
+
+//stop 停止后台登记，写入未保存的修改，未登记的地址保留在队列中
+func (q *informQueue) stop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.saveDirtyLocked()
+	if !q.running {
+		return
+	}
+	close(q.quit)
+	q.running = false
+}
+
+func (q *informQueue) run(quit chan struct{}) {
+	for {
+		q.flush()
+
+		interval := q.wm.CurrentConfig().InformInterval
+		if interval <= 0 {
+			interval = defaultInformInterval
+		}
+		select {
+		case <-quit:
+			return
+		case <-q.wake:
+		case <-time.After(interval):
+		}
+	}
+}
+
+//flush 登记一批到期的地址，返回登记成功的数量
+func (q *informQueue) flush() int {
+
+	if q.wm.client() == nil {
+		return 0
+	}
+
+	//同一时间只有一批登记，避免重复登记
+	q.flushMu.Lock()
+	defer q.flushMu.Unlock()
+
+	batchSize := q.wm.CurrentConfig().InformBatchSize
+	if batchSize <= 0 {
+		batchSize = defaultInformBatchSize
+	}
+
+	q.mu.Lock()
+	batch := make([]InformRecord, 0, batchSize)
+	now := time.Now()
+	for _, r := range q.listLocked() {
+		if len(batch) >= batchSize {
+			break
+		}
+		if !r.NextRetry.After(now) {
+			batch = append(batch, *r)
+		}
+	}
+	q.mu.Unlock()
+
+	if len(batch) == 0 {
+		return 0
+	}
+
+	//钱包服务只有单个地址的coin/inform接口，没有批量登记接口，一批地址逐个登记
+	errs := make([]error, len(batch))
+	for i, r := range batch {
+		errs[i] = q.wm.InformWallet(r.Address, r.Symbol)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	registered := 0
+	for i, r := range batch {
+		key := informKey(r.Address, r.Symbol)
+		record, exist := q.pending[key]
+		if !exist {
+			continue
+		}
+		if errs[i] == nil {
+			delete(q.pending, key)
+			registered++
+			continue
+		}
+		record.Attempts++
+		record.LastError = errs[i].Error()
+		record.NextRetry = time.Now().Add(informBackoff(q.wm.CurrentConfig().InformInterval, record.Attempts))
+		q.wm.Log.Std.Warning("inform address %s failed %d times, err: %v", r.Address, record.Attempts, errs[i])
+	}
+	q.markDirtyLocked()
+	if registered > 0 {
+		q.wm.Log.Infof("informed %d addresses, %d pending", registered, len(q.pending))
+	}
+	return registered
+}
+
+//informBackoff 重试间隔按失败次数倍增，最长10分钟
+func informBackoff(interval time.Duration, attempts int) time.Duration {
+	if interval <= 0 {
+		interval = defaultInformInterval
+	}
+	backoff := interval
+	for i := 1; i < attempts && backoff < defaultInformMaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > defaultInformMaxBackoff {
+		backoff = defaultInformMaxBackoff
+	}
+	return backoff
+}
+
+//EnqueueInform 地址加入登记队列，由后台向钱包服务登记
+func (wm *WalletManager) EnqueueInform(address, symbol string) error {
+	return wm.inform.add(address, symbol)
+}
+
+//FlushInformQueue 立即登记一批到期的地址，返回登记成功的数量
+func (wm *WalletManager) FlushInformQueue() int {
+	return wm.inform.flush()
+}
+
+//StopInformQueue 停止后台登记，未保存的队列立即写入文件
+func (wm *WalletManager) StopInformQueue() {
+	wm.inform.stop()
+}
+
+//UnregisteredAddresses 还未向钱包服务登记的地址
+func (wm *WalletManager) UnregisteredAddresses() []InformRecord {
+	wm.inform.mu.Lock()
+	defer wm.inform.mu.Unlock()
+	records := make([]InformRecord, 0, len(wm.inform.pending))
+	for _, r := range wm.inform.listLocked() {
+		records = append(records, *r)
+	}
+	return records
+}
@@ -0,0 +1,112 @@
+package xpay
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressEncode_InformQueue(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setInformOK(false)
+	dataDir := newTempDir(t)
+
+	wm := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	pub, _ := hex.DecodeString(testAddress("inform"))
+
+	//钱包服务不可用时地址编码不受影响
+	address, err := wm.Decoder.AddressEncode(pub)
+	if err != nil || address != testAddress("inform") {
+		t.Fatalf("AddressEncode = %s, %v", address, err)
+	}
+	wm.Decoder.AddressEncode(pub)
+
+	if !waitFor(5*time.Second, func() bool {
+		records := wm.UnregisteredAddresses()
+		return len(records) == 1 && records[0].Attempts > 0
+	}) {
+		t.Fatalf("inform failure is not recorded: %+v", wm.UnregisteredAddresses())
+	}
+	wm.StopInformQueue()
+
+	//重启后继续登记
+	server.setInformOK(true)
+	wm2 := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	defer wm2.StopInformQueue()
+	records := wm2.UnregisteredAddresses()
+	if len(records) != 1 || records[0].Address != address || records[0].LastError == "" {
+		t.Fatalf("queue is not persisted: %+v", records)
+	}
+	wm2.inform.mu.Lock()
+	wm2.inform.pending[informKey(address, Symbol)].NextRetry = time.Now()
+	wm2.inform.mu.Unlock()
+	wm2.inform.notify()
+
+	if !waitFor(5*time.Second, func() bool { return len(wm2.UnregisteredAddresses()) == 0 }) {
+		t.Fatalf("address is not informed: %+v", wm2.UnregisteredAddresses())
+	}
+	if informed := server.informedAddresses(); len(informed) != 1 || informed[0] != address {
+		t.Errorf("informed = %v, want [%s]", informed, address)
+	}
+
+	wm2.StopInformQueue()
+	wm3 := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	if records := wm3.UnregisteredAddresses(); len(records) != 0 {
+		t.Errorf("registered address is still in queue: %+v", records)
+	}
+}
+
+func TestInformQueue_BatchedSave(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setInformOK(false)
+	dataDir := newTempDir(t)
+
+	wm := newMockWalletManager(t, server, "DataDir = "+dataDir+"\n")
+	path := filepath.Join(dataDir, wm.localFile("inform_queue.json"))
+	saved := func() int {
+		records := make([]*InformRecord, 0)
+		data, _ := ioutil.ReadFile(path)
+		json.Unmarshal(data, &records)
+		return len(records)
+	}
+
+	//批量入队只标记修改，延迟合并写入
+	addresses := make([]string, 200)
+	for i := range addresses {
+		addresses[i] = testAddress(fmt.Sprintf("bulk%d", i))
+	}
+	for _, address := range addresses {
+		wm.EnqueueInform(address, Symbol)
+	}
+	if n := saved(); n == 200 {
+		t.Errorf("queue is saved on every enqueue")
+	}
+	if !waitFor(5*time.Second, func() bool { return saved() == 200 }) {
+		t.Fatalf("saved %d records, want 200", saved())
+	}
+
+	//停止时立即写入未保存的修改
+	wm.EnqueueInform(testAddress("last"), Symbol)
+	wm.StopInformQueue()
+	if n := saved(); n != 201 {
+		t.Errorf("saved %d records after stop, want 201", n)
+	}
+}
+
+func TestInformBackoff(t *testing.T) {
+	if d := informBackoff(10*time.Second, 1); d != 10*time.Second {
+		t.Errorf("backoff(1) = %v", d)
+	}
+	if d := informBackoff(10*time.Second, 3); d != 40*time.Second {
+		t.Errorf("backoff(3) = %v", d)
+	}
+	if d := informBackoff(10*time.Second, 100); d != defaultInformMaxBackoff {
+		t.Errorf("backoff(100) = %v", d)
+	}
+}
@@ -23,35 +23,95 @@ import (
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/imroc/req"
+	"github.com/shopspring/decimal"
 	"github.com/tidwall/gjson"
+	"sync"
+	"sync/atomic"
 )
 
 type WalletManager struct {
 	openwallet.AssetsAdapterBase
 
-	client       *Client                       // 节点客户端
-	Config       *WalletConfig                 // 节点配置
-	Decoder    openwallet.AddressDecoderV2   //地址编码器V2
-	TxDecoder    openwallet.TransactionDecoder //交易单编码器
-	Log          *log.OWLogger                 //日志工具
-	Blockscanner openwallet.BlockScanner       //区块扫描器
+	Config          *WalletConfig                   // 节点配置，加载配置时更新；运行中重新加载时并发读取使用CurrentConfig
+	state           atomic.Value                    // 节点配置和客户端，*walletState
+	Decoder         openwallet.AddressDecoderV2     //地址编码器V2
+	TxDecoder       openwallet.TransactionDecoder   //交易单编码器
+	ContractDecoder openwallet.SmartContractDecoder //平台资产解析器
+	Log             *log.OWLogger                   //日志工具
+	Blockscanner    openwallet.BlockScanner         //区块扫描器
+	senderMu        sync.RWMutex
+	senderDefault   SenderStrategy            //SetSenderStrategy设置的默认策略
+	senderAccounts  map[string]SenderStrategy //SetSenderStrategy设置的账户策略
+	fees            *feeOracle
+	inform          *informQueue
+	reloadMu        sync.Mutex
+	watchMu         sync.Mutex
+	watchQuit       chan struct{}
 }
 
 func NewWalletManager() *WalletManager {
 	wm := WalletManager{}
-	wm.Config = NewConfig(Symbol)
+	wm.storeState(NewConfig(Symbol), nil)
 	wm.Blockscanner = NewBlockScanner(&wm)
 	wm.Decoder = NewAddressDecoderV2(&wm)
 	wm.TxDecoder = NewTransactionDecoder(&wm)
+	wm.ContractDecoder = NewContractDecoder(&wm)
+	wm.fees = &feeOracle{}
+	wm.inform = newInformQueue(&wm)
 	wm.Log = log.NewOWLogger(wm.Symbol())
 	return &wm
 }
 
+//walletState 配置和API客户端，加载配置时整体替换，发布后不再修改
+type walletState struct {
+	config *WalletConfig
+	client *Client
+}
+
+//snapshot 当前的配置和客户端，需要两者一致时使用
+func (wm *WalletManager) snapshot() *walletState {
+	return wm.state.Load().(*walletState)
+}
+
+//CurrentConfig 当前配置，重新加载后返回新的配置，可以与重新加载并发调用，调用者不能修改
+func (wm *WalletManager) CurrentConfig() *WalletConfig {
+	return wm.snapshot().config
+}
+
+//client 当前API客户端，没有加载配置时为nil
+func (wm *WalletManager) client() *Client {
+	return wm.snapshot().client
+}
+
+//storeState 整体替换配置和客户端，正在进行的调用继续使用替换前取得的配置和客户端
+//Config字段同时更新，兼容直接读取字段的调用者，包内只通过CurrentConfig读取
+func (wm *WalletManager) storeState(cfg *WalletConfig, client *Client) {
+	wm.state.Store(&walletState{config: cfg, client: client})
+	wm.Config = cfg
+}
+
 func (wm *WalletManager) GetWalletDetails(address string) (*XIFAccount, error) {
 
 	path := fmt.Sprintf("coin/%s", address)
 
-	result, err := wm.client.call("GET", path, nil)
+	result, err := wm.client().call("GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewXIFAccount(result), nil
+}
+
+// GetTokenDetails 查询地址在平台资产上的账户
+func (wm *WalletManager) GetTokenDetails(address, symbol string) (*XIFAccount, error) {
+
+	path := fmt.Sprintf("coin/%s", address)
+
+	pararm := req.Param{
+		"symbol": symbol,
+	}
+
+	result, err := wm.client().call("GET", path, pararm)
 	if err != nil {
 		return nil, err
 	}
@@ -67,7 +127,7 @@ func (wm *WalletManager) NewWallet(symbol string) (*gjson.Result, error) {
 		"symbol": symbol,
 	}
 
-	result, err := wm.client.call("POST", path, pararm)
+	result, err := wm.client().call("POST", path, pararm)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +138,7 @@ func (wm *WalletManager) NewWallet(symbol string) (*gjson.Result, error) {
 func (wm *WalletManager) GetLatestBlock() (*Block, error) {
 
 	path := fmt.Sprintf("coin/blocks/latest")
-	result, err := wm.client.call("GET", path, nil)
+	result, err := wm.client().call("GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -88,7 +148,7 @@ func (wm *WalletManager) GetLatestBlock() (*Block, error) {
 func (wm *WalletManager) GetBlock(num uint64) (*Block, error) {
 
 	path := fmt.Sprintf("coin/blocks/%d", num)
-	result, err := wm.client.call("GET", path, nil)
+	result, err := wm.client().call("GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +158,7 @@ func (wm *WalletManager) GetBlock(num uint64) (*Block, error) {
 func (wm *WalletManager) GetTransaction(txid string) (*Transaction, error) {
 
 	path := fmt.Sprintf("coin/transaction/%s", txid)
-	result, err := wm.client.call("GET", path, nil)
+	result, err := wm.client().call("GET", path, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -113,12 +173,15 @@ func (wm *WalletManager) Sendraw(rawTx *RawTransaction) (string, error) {
 		"sender":    rawTx.Sender,
 		"recipient": rawTx.Recipient,
 		"symbol":    rawTx.Symbol,
-		"amount":    rawTx.Amount,
+		"amount":    CanonicalAmount(rawTx.Amount),
 		"nonce":     rawTx.Nonce,
 		"signature": rawTx.Signature,
 	}
+	if rawTx.Notes != "" {
+		pararm["notes"] = rawTx.Notes
+	}
 
-	result, err := wm.client.call("POST", path, pararm)
+	result, err := wm.client().call("POST", path, pararm)
 	if err != nil {
 		return "", err
 	}
@@ -134,12 +197,15 @@ func (wm *WalletManager) SignRawTxOnline(rawTx *RawTransaction, privateKey []byt
 		"sender":     rawTx.Sender,
 		"recipient":  rawTx.Recipient,
 		"symbol":     rawTx.Symbol,
-		"amount":     rawTx.Amount,
+		"amount":     CanonicalAmount(rawTx.Amount),
 		"nonce":      rawTx.Nonce,
 		"privatekey": hex.EncodeToString(privateKey),
 	}
+	if rawTx.Notes != "" {
+		pararm["notes"] = rawTx.Notes
+	}
 
-	result, err := wm.client.call("POST", path, pararm)
+	result, err := wm.client().call("POST", path, pararm)
 	if err != nil {
 		return err
 	}
@@ -149,7 +215,7 @@ func (wm *WalletManager) SignRawTxOnline(rawTx *RawTransaction, privateKey []byt
 
 func (wm *WalletManager) SignRawTxOffline(rawTx *RawTransaction, privateKey []byte) error {
 
-	messageHash := rawTx.Hash()
+	messageHash := rawTx.Hash(wm.CurrentConfig().SignNotes)
 	signature, _, ret := owcrypt.Signature(privateKey, nil, messageHash, wm.CurveType())
 	if ret != owcrypt.SUCCESS {
 		return fmt.Errorf("sign raw tx failed")
@@ -169,7 +235,7 @@ func (wm *WalletManager) SignRawTxOffline(rawTx *RawTransaction, privateKey []by
 // GetAddressNonce
 func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, account *XIFAccount) uint64 {
 	var (
-		key           = wm.Symbol() + "-nonce"
+		key           = wm.localKey("nonce")
 		nonce         uint64
 		nonce_db      interface{}
 		nonce_onchain uint64
@@ -200,13 +266,41 @@ func (wm *WalletManager) GetAddressNonce(wrapper openwallet.WalletDAI, account *
 
 // UpdateAddressNonce
 func (wm *WalletManager) UpdateAddressNonce(wrapper openwallet.WalletDAI, address string, nonce uint64) {
-	key := wm.Symbol() + "-nonce"
+	key := wm.localKey("nonce")
 	err := wrapper.SetAddressExtParam(address, key, nonce)
 	if err != nil {
 		wm.Log.Errorf("WalletDAI SetAddressExtParam failed, err: %v", err)
 	}
 }
 
+// GetNetworkFees 查询当前网络手续费
+func (wm *WalletManager) GetNetworkFees() (decimal.Decimal, error) {
+	return wm.networkFees(wm.snapshot())
+}
+
+//networkFees 用同一次加载的配置和客户端查询手续费
+func (wm *WalletManager) networkFees(state *walletState) (decimal.Decimal, error) {
+
+	result, err := state.client.call("GET", state.config.FeeAPIPath, nil)
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	fees, err := ParseTransferAmount(result.Get("fee").String())
+	if err != nil {
+		return decimal.Zero, fmt.Errorf("invalid network fees: %v", err)
+	}
+	return fees.Decimal(), nil
+}
+
+// CircuitStatus API熔断器状态，用于健康检查
+func (wm *WalletManager) CircuitStatus() CircuitStatus {
+	if wm.client() == nil {
+		return CircuitStatus{State: CircuitClosed}
+	}
+	return wm.client().CircuitStatus()
+}
+
 // InformWallet
 func (wm *WalletManager) InformWallet(address, symbol string) error {
 	path := fmt.Sprintf("coin/inform")
@@ -216,7 +310,7 @@ func (wm *WalletManager) InformWallet(address, symbol string) error {
 		"symbol":    symbol,
 	}
 
-	_, err := wm.client.call("POST", path, pararm)
+	_, err := wm.client().call("POST", path, pararm)
 	if err != nil {
 		return err
 	}
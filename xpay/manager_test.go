@@ -43,7 +43,7 @@ func testNewWalletManager() *WalletManager {
 		return nil
 	}
 	wm.LoadAssetsConfig(c)
-	wm.client.Debug = true
+	wm.client().Debug = true
 
 	return wm
 }
@@ -0,0 +1,605 @@
+package xpay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//mockServer XIF API的本地替身，用于离线测试
+type mockServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	blocks   map[uint64]map[string]interface{}
+	latest   uint64
+	txs      map[string]map[string]interface{}
+	accounts map[string]map[string]interface{}
+	sent     []map[string]string //收到的sendraw请求
+	failSend map[string]bool     //sendraw失败一次的nonce
+	informed []string            //收到的inform请求
+	streams  map[chan string]bool
+	silent   bool //新区块不推送，连接只发送保持连接的注释
+	fee      string //coin/fee返回的手续费，为空时返回错误
+	informOK bool   //coin/inform是否成功
+	queries  int    //账户查询次数
+}
+
+//mockNetworkCheck 所有网络都必须配置校验hash，mockServer在高度1固定提供校验区块
+const mockNetworkCheck = "NetworkCheckHeight = 1\nNetworkCheckHash = mock-genesis\n"
+
+func newMockServer() *mockServer {
+	s := &mockServer{
+		blocks:   make(map[uint64]map[string]interface{}),
+		txs:      make(map[string]map[string]interface{}),
+		accounts: make(map[string]map[string]interface{}),
+		streams:  make(map[chan string]bool),
+		informOK: true,
+	}
+	//校验区块不在扫描的链上，addBlock从高度100开始
+	s.blocks[1] = map[string]interface{}{"id": 1, "hash": "mock-genesis", "last_hash": "", "txns": []string{}, "created": time.Now().UTC().Format(TimeLayout)}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+//addBlock 在链尾增加一个区块，并推送给订阅者
+func (s *mockServer) addBlock(hash string, txns ...string) uint64 {
+	s.mu.Lock()
+	height := s.latest + 1
+	if s.latest == 0 {
+		height = 100
+	}
+	lastHash := ""
+	if prev, ok := s.blocks[s.latest]; ok {
+		lastHash = prev["hash"].(string)
+	}
+	if txns == nil {
+		txns = []string{}
+	}
+	block := map[string]interface{}{
+		"id":        height,
+		"hash":      hash,
+		"last_hash": lastHash,
+		"txns":      txns,
+		"created":   time.Now().UTC().Format(TimeLayout),
+	}
+	s.blocks[height] = block
+	s.latest = height
+	streams := make([]chan string, 0, len(s.streams))
+	for ch := range s.streams {
+		if !s.silent {
+			streams = append(streams, ch)
+		}
+	}
+	s.mu.Unlock()
+
+	data, _ := json.Marshal(block)
+	for _, ch := range streams {
+		ch <- string(data)
+	}
+	return height
+}
+
+//addTransaction 增加一笔交易，height为所在区块
+func (s *mockServer) addTransaction(txid string, height uint64, from, to, symbol, amount, memo string) map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tx := map[string]interface{}{
+		"key":               txid,
+		"owner":             to,
+		"created":           time.Now().UTC().Format(TimeLayout),
+		"sender_account":    from,
+		"recipient_account": to,
+		"amount":            amount,
+		"symbol":            symbol,
+		"type":              "TRANSFER",
+		"block":             strconv.FormatUint(height, 10),
+		"notes":             memo,
+		"status":            "COMPLETED",
+	}
+	if block, ok := s.blocks[height]; ok {
+		tx["hash"] = block["hash"]
+	}
+	s.txs[txid] = tx
+	return tx
+}
+
+//setFee 设置网络手续费
+func (s *mockServer) setFee(fee string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fee = fee
+}
+
+//setInformOK 设置coin/inform是否成功
+func (s *mockServer) setInformOK(ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.informOK = ok
+}
+
+//informedAddresses 已登记的地址
+func (s *mockServer) informedAddresses() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.informed...)
+}
+
+//setAccount 设置地址在symbol上的余额和nonce
+func (s *mockServer) setAccount(address, symbol, amount string, nonce uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.accounts[address+"/"+symbol] = map[string]interface{}{
+		"address":   address,
+		"publickey": address,
+		"symbol":    symbol,
+		"amount":    amount,
+		"nonce":     nonce,
+		"type":      "WALLET",
+	}
+}
+
+//setSendFail 指定nonce的交易广播失败一次
+func (s *mockServer) setSendFail(nonce uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failSend == nil {
+		s.failSend = make(map[string]bool)
+	}
+	s.failSend[strconv.FormatUint(nonce, 10)] = true
+}
+
+//setSilent 新区块是否停止推送
+func (s *mockServer) setSilent(silent bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silent = silent
+}
+
+//closeStreams 断开所有推送连接
+func (s *mockServer) closeStreams() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.streams {
+		close(ch)
+		delete(s.streams, ch)
+	}
+}
+
+func (s *mockServer) writeJSON(w http.ResponseWriter, obj interface{}) {
+	data, _ := json.Marshal(obj)
+	w.Write(data)
+}
+
+func (s *mockServer) writeError(w http.ResponseWriter, msg string) {
+	s.writeJSON(w, map[string]interface{}{"error": map[string]interface{}{"message": msg}})
+}
+
+func (s *mockServer) handle(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case path == "coin/blocks/subscribe":
+		s.handleStream(w, r)
+	case path == "coin/blocks/latest":
+		s.mu.Lock()
+		block := s.blocks[s.latest]
+		s.mu.Unlock()
+		s.writeJSON(w, block)
+	case strings.HasPrefix(path, "coin/blocks/"):
+		height, _ := strconv.ParseUint(strings.TrimPrefix(path, "coin/blocks/"), 10, 64)
+		s.mu.Lock()
+		block, ok := s.blocks[height]
+		s.mu.Unlock()
+		if !ok {
+			s.writeError(w, "block not found")
+			return
+		}
+		s.writeJSON(w, block)
+	case strings.HasPrefix(path, "coin/transaction/"):
+		s.mu.Lock()
+		tx, ok := s.txs[strings.TrimPrefix(path, "coin/transaction/")]
+		s.mu.Unlock()
+		if !ok {
+			s.writeError(w, "transaction not found")
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{"transaction": tx})
+	case path == "coin/sendraw":
+		r.ParseForm()
+		sent := make(map[string]string)
+		for k := range r.PostForm {
+			sent[k] = r.PostForm.Get(k)
+		}
+		s.mu.Lock()
+		if s.failSend[sent["nonce"]] {
+			delete(s.failSend, sent["nonce"])
+			s.mu.Unlock()
+			s.writeError(w, "sendraw failed")
+			return
+		}
+		s.sent = append(s.sent, sent)
+		txid := fmt.Sprintf("tx%d", len(s.sent))
+		s.mu.Unlock()
+		s.writeJSON(w, map[string]interface{}{"txn": txid})
+	case path == "coin/fee":
+		s.mu.Lock()
+		fee := s.fee
+		s.mu.Unlock()
+		if fee == "" {
+			s.writeError(w, "fee not available")
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{"fee": fee})
+	case path == "coin/inform":
+		r.ParseForm()
+		s.mu.Lock()
+		ok := s.informOK
+		if ok {
+			s.informed = append(s.informed, r.PostForm.Get("publickey"))
+		}
+		s.mu.Unlock()
+		if !ok {
+			s.writeError(w, "inform not available")
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{})
+	case strings.HasPrefix(path, "coin/"):
+		symbol := r.URL.Query().Get("symbol")
+		if symbol == "" {
+			symbol = Symbol
+		}
+		s.mu.Lock()
+		account, ok := s.accounts[strings.TrimPrefix(path, "coin/")+"/"+symbol]
+		s.queries++
+		s.mu.Unlock()
+		if !ok {
+			s.writeError(w, "account not found")
+			return
+		}
+		s.writeJSON(w, map[string]interface{}{"account": account})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+//handleStream 新区块SSE推送
+func (s *mockServer) handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	ch := make(chan string, 16)
+	s.mu.Lock()
+	s.streams[ch] = true
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(": connected\n\n"))
+	flusher.Flush()
+
+	keepalive := time.NewTicker(100 * time.Millisecond)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-keepalive.C:
+			w.Write([]byte(": keepalive\n\n"))
+			flusher.Flush()
+		case data, ok := <-ch:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(w, "event: block\ndata: %s\n\n", data)
+			flusher.Flush()
+		case <-r.Context().Done():
+			s.mu.Lock()
+			delete(s.streams, ch)
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+//testDataDir 测试临时目录的根目录，全部测试结束后删除
+var testDataDir string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "xpay")
+	if err != nil {
+		fmt.Printf("TempDir failed, err: %v\n", err)
+		os.Exit(1)
+	}
+	testDataDir = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+//newTempDir 创建测试使用的临时目录
+func newTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir(testDataDir, "test")
+	if err != nil {
+		t.Fatalf("TempDir failed, err: %v", err)
+	}
+	return dir
+}
+
+//newMockWalletManager 连接到mockServer的WalletManager，extra为附加的ini配置
+func newMockWalletManager(t *testing.T, server *mockServer, extra string) *WalletManager {
+	wm := NewWalletManager()
+	c, err := config.NewConfigData("ini", []byte("ServerAPI = "+server.URL+"\nFixFees = 0.01\nDataDir = "+newTempDir(t)+"\n"+mockNetworkCheck+extra))
+	if err != nil {
+		t.Fatalf("NewConfigData failed, err: %v", err)
+	}
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed, err: %v", err)
+	}
+	return wm
+}
+
+//mockBlockchainDAI 内存中的区块链数据
+type mockBlockchainDAI struct {
+	mu      sync.Mutex
+	head    *openwallet.BlockHeader
+	blocks  map[uint64]*openwallet.BlockHeader
+	unscans map[string]*openwallet.UnscanRecord
+}
+
+func newMockBlockchainDAI() *mockBlockchainDAI {
+	return &mockBlockchainDAI{
+		blocks:  make(map[uint64]*openwallet.BlockHeader),
+		unscans: make(map[string]*openwallet.UnscanRecord),
+	}
+}
+
+func (dai *mockBlockchainDAI) SaveCurrentBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.head = header
+	return nil
+}
+
+func (dai *mockBlockchainDAI) GetCurrentBlockHead(symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	if dai.head == nil {
+		return nil, fmt.Errorf("block head not found")
+	}
+	return dai.head, nil
+}
+
+func (dai *mockBlockchainDAI) SaveLocalBlockHead(header *openwallet.BlockHeader) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.blocks[header.Height] = header
+	return nil
+}
+
+func (dai *mockBlockchainDAI) GetLocalBlockHeadByHeight(height uint64, symbol string) (*openwallet.BlockHeader, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	header, ok := dai.blocks[height]
+	if !ok {
+		return nil, fmt.Errorf("block not found")
+	}
+	return header, nil
+}
+
+func (dai *mockBlockchainDAI) SaveUnscanRecord(record *openwallet.UnscanRecord) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.unscans[record.ID] = record
+	return nil
+}
+
+func (dai *mockBlockchainDAI) DeleteUnscanRecordByHeight(height uint64, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	for id, r := range dai.unscans {
+		if r.BlockHeight == height {
+			delete(dai.unscans, id)
+		}
+	}
+	return nil
+}
+
+func (dai *mockBlockchainDAI) DeleteUnscanRecordByID(id string, symbol string) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	delete(dai.unscans, id)
+	return nil
+}
+
+func (dai *mockBlockchainDAI) GetTransactionsByTxID(txid, symbol string) ([]*openwallet.Transaction, error) {
+	return nil, fmt.Errorf("transaction not found")
+}
+
+func (dai *mockBlockchainDAI) GetUnscanRecords(symbol string) ([]*openwallet.UnscanRecord, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	list := make([]*openwallet.UnscanRecord, 0, len(dai.unscans))
+	for _, r := range dai.unscans {
+		list = append(list, r)
+	}
+	return list, nil
+}
+
+func (dai *mockBlockchainDAI) SetMaxBlockCache(max uint64, symbol string) error {
+	return nil
+}
+
+//mockWalletDAI 内存中的钱包数据
+type mockWalletDAI struct {
+	openwallet.WalletDAIBase
+	mu        sync.Mutex
+	addresses []*openwallet.Address
+	extParams map[string]interface{}
+}
+
+func newMockWalletDAI(accountID string, addresses ...string) *mockWalletDAI {
+	dai := &mockWalletDAI{extParams: make(map[string]interface{})}
+	for i, a := range addresses {
+		dai.addresses = append(dai.addresses, &openwallet.Address{
+			AccountID: accountID,
+			Address:   a,
+			PublicKey: a,
+			Index:     uint64(i),
+			HDPath:    fmt.Sprintf("m/44'/88'/0'/0/%d", i),
+			Symbol:    Symbol,
+		})
+	}
+	return dai
+}
+
+func (dai *mockWalletDAI) GetAddress(address string) (*openwallet.Address, error) {
+	for _, a := range dai.addresses {
+		if a.Address == address {
+			return a, nil
+		}
+	}
+	return nil, fmt.Errorf("address not found")
+}
+
+//GetAddressList 支持按AccountID和Address过滤
+func (dai *mockWalletDAI) GetAddressList(offset, limit int, cols ...interface{}) ([]*openwallet.Address, error) {
+	list := make([]*openwallet.Address, 0)
+	for _, a := range dai.addresses {
+		match := true
+		for i := 0; i+1 < len(cols); i += 2 {
+			switch cols[i] {
+			case "AccountID":
+				match = match && a.AccountID == cols[i+1]
+			case "Address":
+				match = match && a.Address == cols[i+1]
+			}
+		}
+		if match {
+			list = append(list, a)
+		}
+	}
+	if offset > len(list) {
+		offset = len(list)
+	}
+	list = list[offset:]
+	if limit >= 0 && limit < len(list) {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+func (dai *mockWalletDAI) SetAddressExtParam(address string, key string, val interface{}) error {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	dai.extParams[address+"/"+key] = val
+	return nil
+}
+
+func (dai *mockWalletDAI) GetAddressExtParam(address string, key string) (interface{}, error) {
+	dai.mu.Lock()
+	defer dai.mu.Unlock()
+	return dai.extParams[address+"/"+key], nil
+}
+
+//mockObserver 记录扫描器的通知
+type mockObserver struct {
+	mu      sync.Mutex
+	headers []*openwallet.BlockHeader
+	data    map[string][]*openwallet.TxExtractData
+}
+
+func newMockObserver() *mockObserver {
+	return &mockObserver{data: make(map[string][]*openwallet.TxExtractData)}
+}
+
+func (o *mockObserver) BlockScanNotify(header *openwallet.BlockHeader) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.headers = append(o.headers, header)
+	return nil
+}
+
+func (o *mockObserver) BlockExtractDataNotify(sourceKey string, data *openwallet.TxExtractData) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.data[sourceKey] = append(o.data[sourceKey], data)
+	return nil
+}
+
+func (o *mockObserver) BlockExtractSmartContractDataNotify(sourceKey string, data *openwallet.SmartContractReceipt) error {
+	return nil
+}
+
+//scannedHeight 已通知的最大非分叉区块高度
+func (o *mockObserver) scannedHeight() uint64 {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var height uint64
+	for _, h := range o.headers {
+		if !h.Fork && h.Height > height {
+			height = h.Height
+		}
+	}
+	return height
+}
+
+//newTestKey 生成测试私钥和对应的地址（压缩公钥）
+func newTestKey(t *testing.T) ([]byte, string) {
+	priv := make([]byte, 32)
+	if _, err := rand.Read(priv); err != nil {
+		t.Fatalf("generate private key failed, err: %v", err)
+	}
+	pub, ret := owcrypt.GenPubkey(priv, CurveType)
+	if ret != owcrypt.SUCCESS {
+		t.Fatalf("generate public key failed")
+	}
+	return priv, hex.EncodeToString(owcrypt.PointCompress(pub, CurveType))
+}
+
+//testAddress 由名称确定性生成的有效地址，用于接收地址
+func testAddress(name string) string {
+	priv := owcrypt.Hash([]byte(name), 0, owcrypt.HASH_ALG_SHA256)
+	pub, _ := owcrypt.GenPubkey(priv, CurveType)
+	return hex.EncodeToString(owcrypt.PointCompress(pub, CurveType))
+}
+
+//signRawTransaction 使用地址对应的私钥签名交易单的全部待签消息
+func signRawTransaction(t *testing.T, rawTx *openwallet.RawTransaction, keys map[string][]byte) {
+	for _, keySignature := range rawTx.Signatures[rawTx.Account.AccountID] {
+		priv, ok := keys[keySignature.Address.Address]
+		if !ok {
+			t.Fatalf("private key of %s not found", keySignature.Address.Address)
+		}
+		msg, _ := hex.DecodeString(keySignature.Message)
+		sig, _, ret := owcrypt.Signature(priv, nil, msg, keySignature.EccType)
+		if ret != owcrypt.SUCCESS {
+			t.Fatalf("sign message failed")
+		}
+		keySignature.Signature = hex.EncodeToString(sig)
+	}
+}
+
+//waitFor 等待条件成立
+func waitFor(timeout time.Duration, cond func() bool) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
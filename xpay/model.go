@@ -16,7 +16,9 @@
 package xpay
 
 import (
+	"bytes"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/openwallet"
@@ -117,6 +119,10 @@ type Transaction struct {
 	TxType      string
 	Memo        string
 	Timestamp   time.Time
+	Signature   string
+	Nonce       uint64 //0表示接口没有返回nonce
+
+	verifyErr error //签名校验失败的原因
 }
 
 func NewTransaction(result *gjson.Result) *Transaction {
@@ -132,6 +138,8 @@ func NewTransaction(result *gjson.Result) *Transaction {
 	obj.Status = result.Get("transaction.status").String()
 	obj.TxType = result.Get("transaction.type").String()
 	obj.Memo = result.Get("transaction.notes").String()
+	obj.Signature = result.Get("transaction.signature").String()
+	obj.Nonce = result.Get("transaction.nonce").Uint()
 	obj.Timestamp, _ = time.ParseInLocation(TimeLayout, result.Get("transaction.created").String(), time.UTC)
 	return &obj
 }
@@ -142,11 +150,16 @@ type RawTransaction struct {
 	Symbol    string `json:"symbol"`
 	Amount    string `json:"amount"`
 	Nonce     uint64 `json:"nonce"`
+	Notes     string `json:"notes,omitempty"`
 	Signature string `json:"signature"`
 }
 
-func (rawTx *RawTransaction) Hash() []byte {
-	message := fmt.Sprintf("%s%s%s%s%d", rawTx.Sender, rawTx.Recipient, rawTx.Symbol, rawTx.Amount, rawTx.Nonce)
+//Hash 签名消息，数量使用规范格式，signNotes为true时备注追加在nonce之后一起签名
+func (rawTx *RawTransaction) Hash(signNotes bool) []byte {
+	message := fmt.Sprintf("%s%s%s%s%d", rawTx.Sender, rawTx.Recipient, rawTx.Symbol, CanonicalAmount(rawTx.Amount), rawTx.Nonce)
+	if signNotes {
+		message += rawTx.Notes
+	}
 	messageHash := owcrypt.Hash([]byte(message), 0, owcrypt.HASH_ALG_SHA256)
 	return messageHash
 }
@@ -164,3 +177,40 @@ func (rawTx *RawTransaction) FillSig(signature []byte) error {
 	rawTx.Signature = hex.EncodeToString(der)
 	return nil
 }
+
+//EncodeRawTransactions 编码交易单，单笔交易保持原有格式，多笔交易编码为数组
+func EncodeRawTransactions(txs []*RawTransaction) string {
+	var txJson []byte
+	if len(txs) == 1 {
+		txJson, _ = json.Marshal(txs[0])
+	} else {
+		txJson, _ = json.Marshal(txs)
+	}
+	return hex.EncodeToString(txJson)
+}
+
+//DecodeRawTransactions 解码交易单，兼容单笔交易和多笔交易数组
+func DecodeRawTransactions(rawHex string) ([]*RawTransaction, error) {
+	txHex, err := hex.DecodeString(rawHex)
+	if err != nil {
+		return nil, fmt.Errorf("transaction decode failed, unexpected error: %v", err)
+	}
+
+	txHex = bytes.TrimSpace(txHex)
+	if len(txHex) > 0 && txHex[0] == '[' {
+		var txs []*RawTransaction
+		if err := json.Unmarshal(txHex, &txs); err != nil {
+			return nil, err
+		}
+		if len(txs) == 0 {
+			return nil, fmt.Errorf("transaction is empty")
+		}
+		return txs, nil
+	}
+
+	var tx RawTransaction
+	if err := json.Unmarshal(txHex, &tx); err != nil {
+		return nil, err
+	}
+	return []*RawTransaction{&tx}, nil
+}
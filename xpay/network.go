@@ -0,0 +1,152 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	NetworkMainnet = "mainnet" //主网
+	NetworkTestnet = "testnet" //测试网
+	NetworkDevnet  = "devnet"  //本地开发网络
+)
+
+//NetworkProfile 网络配置，区分主网、测试网和本地开发网络
+//加载配置时校验API在CheckHeight的区块hash，不一致则拒绝启动
+//CheckRequired为true时必须配置NetworkCheckHash，否则没有校验hash时只输出警告
+//各网络的链上symbol相同，本地记录按网络区分：地址扩展参数用localKey，DataDir文件用localFile
+type NetworkProfile struct {
+	Name          string //网络名称
+	Symbol        string //链上主币symbol
+	CurveType     uint32 //曲线类型
+	ServerAPI     string //默认API，为空时必须配置ServerAPI
+	FixFees       string //默认手续费，为空时必须配置FixFees
+	CheckHeight   uint64 //校验区块高度
+	CheckHash     string //校验区块hash
+	CheckRequired bool   //没有校验hash时拒绝启动
+}
+
+//NetworkProfiles 内置的网络配置
+//主网的校验区块hash还没有内置，测试网和开发网络会重置，没有固定的hash，
+//所有网络都必须从可信的节点获取校验区块hash，配置NetworkCheckHash
+var NetworkProfiles = map[string]*NetworkProfile{
+	NetworkMainnet: {
+		Name:          NetworkMainnet,
+		Symbol:        Symbol,
+		CurveType:     CurveType,
+		ServerAPI:     "https://federation.xifapi.com/",
+		FixFees:       "0.01",
+		CheckHeight:   1,
+		CheckRequired: true,
+	},
+	NetworkTestnet: {
+		Name:          NetworkTestnet,
+		Symbol:        Symbol,
+		CurveType:     CurveType,
+		FixFees:       "0.01",
+		CheckHeight:   1,
+		CheckRequired: true,
+	},
+	NetworkDevnet: {
+		Name:          NetworkDevnet,
+		Symbol:        Symbol,
+		CurveType:     CurveType,
+		FixFees:       "0.01",
+		CheckHeight:   1,
+		CheckRequired: true,
+	},
+}
+
+//GetNetworkProfile 按名称获取网络配置
+func GetNetworkProfile(name string) (*NetworkProfile, error) {
+	profile, ok := NetworkProfiles[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(NetworkProfiles))
+		for n := range NetworkProfiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown network %q, want one of %s", name, strings.Join(names, ", "))
+	}
+	return profile, nil
+}
+
+//configDefault 网络提供的配置项默认值
+func (p *NetworkProfile) configDefault(key string) string {
+	switch key {
+	case "ServerAPI":
+		return p.ServerAPI
+	case "FixFees":
+		return p.FixFees
+	case "NetworkCheckHash":
+		return p.CheckHash
+	}
+	return ""
+}
+
+//localKey 地址扩展参数的key，主网为 symbol-name，其他网络为 symbol-network-name
+//同一个钱包数据库连接不同网络时，nonce等记录互不影响
+func (wm *WalletManager) localKey(name string) string {
+	if wm.CurrentConfig().Network == "" || wm.CurrentConfig().Network == NetworkMainnet {
+		return wm.Symbol() + "-" + name
+	}
+	return wm.Symbol() + "-" + wm.CurrentConfig().Network + "-" + name
+}
+
+//localFile DataDir中的文件名，主网为 symbol_name，其他网络为 symbol_network_name
+func (wm *WalletManager) localFile(name string) string {
+	return wm.CurrentConfig().localFile(name)
+}
+
+//localFile 配置对应网络的DataDir文件名，加载配置时在启用配置前使用
+func (c *WalletConfig) localFile(name string) string {
+	if c.Network == "" || c.Network == NetworkMainnet {
+		return c.Symbol + "_" + name
+	}
+	return c.Symbol + "_" + c.Network + "_" + name
+}
+
+//CheckNetwork 校验API服务的是配置的网络：API在NetworkCheckHeight的区块hash必须等于NetworkCheckHash
+//没有配置校验hash时，CheckRequired的网络返回错误，其他网络只输出警告
+func (wm *WalletManager) CheckNetwork() error {
+	state := wm.snapshot()
+	return wm.checkNetwork(state.config, state.client)
+}
+
+//checkNetwork 用指定的配置和客户端校验网络，加载和重新加载配置时在启用前调用
+func (wm *WalletManager) checkNetwork(cfg *WalletConfig, client *Client) error {
+	if cfg.NetworkCheckHash == "" {
+		if profile, err := GetNetworkProfile(cfg.Network); err == nil && profile.CheckRequired {
+			return fmt.Errorf("NetworkCheckHash is required for network %s", cfg.Network)
+		}
+		wm.Log.Std.Warning("network %s of %s is not verified, set NetworkCheckHash to refuse a wrong network", cfg.Network, cfg.ServerAPI)
+		return nil
+	}
+	result, err := client.call("GET", fmt.Sprintf("coin/blocks/%d", cfg.NetworkCheckHeight), nil)
+	if err != nil {
+		return fmt.Errorf("check network %s failed: %v", cfg.Network, err)
+	}
+	block := NewBlock(result)
+	if !strings.EqualFold(block.Hash, cfg.NetworkCheckHash) {
+		return fmt.Errorf("API %s does not serve network %s: block %d hash is %s, want %s",
+			cfg.ServerAPI, cfg.Network, cfg.NetworkCheckHeight, block.Hash, cfg.NetworkCheckHash)
+	}
+	wm.Log.Infof("network %s verified at block %d", cfg.Network, cfg.NetworkCheckHeight)
+	return nil
+}
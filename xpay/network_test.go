@@ -0,0 +1,67 @@
+package xpay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/astaxie/beego/config"
+)
+
+func TestNetwork_Check(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("genesis")
+
+	//校验hash一致
+	wm := newMockWalletManager(t, server, "Network = devnet\nNetworkCheckHeight = 100\nNetworkCheckHash = GENESIS\n")
+	if wm.CurrentConfig().Network != NetworkDevnet || wm.Symbol() != Symbol || wm.CurrentConfig().NetworkCheckHash != "GENESIS" {
+		t.Fatalf("network profile is not applied: %+v", wm.CurrentConfig().Network)
+	}
+
+	tests := []struct {
+		extra string
+		err   string
+	}{
+		{"Network = devnet\nNetworkCheckHeight = 100\nNetworkCheckHash = other\n", "does not serve network devnet"},
+		{"Network = testnet\nNetworkCheckHeight = 200\nNetworkCheckHash = genesis\n", "check network testnet failed"},
+		{"Network = moonnet\n", "unknown network"},
+		{"Network = devnet\nNetworkCheckHeight = -1\n", "invalid NetworkCheckHeight"},
+	}
+	for _, test := range tests {
+		c, _ := config.NewConfigData("ini", []byte("ServerAPI = "+server.URL+"\n"+test.extra))
+		err := NewWalletManager().LoadAssetsConfig(c)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("LoadAssetsConfig(%q) = %v, want %q", test.extra, err, test.err)
+		}
+	}
+
+	//所有网络都必须配置校验hash
+	c, _ := config.NewConfigData("ini", []byte("ServerAPI = http://127.0.0.1:1/\nDataDir = "+newTempDir(t)+"\n"))
+	for _, network := range []string{NetworkMainnet, NetworkTestnet, NetworkDevnet} {
+		c.Set("Network", network)
+		if err := NewWalletManager().LoadAssetsConfig(c); err == nil || !strings.Contains(err.Error(), "NetworkCheckHash is required") {
+			t.Errorf("LoadAssetsConfig(%s) without NetworkCheckHash = %v, want required", network, err)
+		}
+	}
+}
+
+func TestNetwork_LocalKeys(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("genesis")
+
+	mainnet := newMockWalletManager(t, server, "")
+	devnet := newMockWalletManager(t, server, "Network = devnet\nNetworkCheckHeight = 100\nNetworkCheckHash = genesis\n")
+	if key := mainnet.localKey("nonce"); key != "XIF-nonce" {
+		t.Errorf("mainnet nonce key = %s, want XIF-nonce", key)
+	}
+	if key := devnet.localKey("nonce"); key != "XIF-devnet-nonce" {
+		t.Errorf("devnet nonce key = %s, want XIF-devnet-nonce", key)
+	}
+	if file := mainnet.localFile("blocks"); file != "XIF_blocks" {
+		t.Errorf("mainnet blocks dir = %s, want XIF_blocks", file)
+	}
+	if file := devnet.localFile("blocks"); file != "XIF_devnet_blocks" {
+		t.Errorf("devnet blocks dir = %s, want XIF_devnet_blocks", file)
+	}
+}
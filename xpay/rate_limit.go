@@ -0,0 +1,171 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRetryAfter       = 1 * time.Second // 429未返回Retry-After时的等待时间
+	defaultRateLimitRetries = 3               // 429最大重试次数
+)
+
+//EndpointLimit 接口限流配置
+type EndpointLimit struct {
+	Rate        float64 //每秒请求数，0为不限制
+	Burst       int     //令牌桶容量，小于1时取1
+	MaxInFlight int     //最大并发请求数，0为不限制
+}
+
+//ParseEndpointLimit 解析接口限流配置，格式：路径前缀:每秒请求数:令牌桶容量:最大并发数
+func ParseEndpointLimit(s string) (string, EndpointLimit, error) {
+	limit := EndpointLimit{}
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 4 {
+		return "", limit, fmt.Errorf("invalid endpoint limit: %s", s)
+	}
+	prefix := strings.Trim(strings.TrimSpace(parts[0]), "/")
+	rate, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil || rate < 0 {
+		return "", limit, fmt.Errorf("invalid endpoint rate: %s", s)
+	}
+	burst, err := strconv.Atoi(strings.TrimSpace(parts[2]))
+	if err != nil || burst < 0 {
+		return "", limit, fmt.Errorf("invalid endpoint burst: %s", s)
+	}
+	maxInFlight, err := strconv.Atoi(strings.TrimSpace(parts[3]))
+	if err != nil || maxInFlight < 0 {
+		return "", limit, fmt.Errorf("invalid endpoint max in flight: %s", s)
+	}
+	limit.Rate = rate
+	limit.Burst = burst
+	limit.MaxInFlight = maxInFlight
+	return prefix, limit, nil
+}
+
+//rateLimiter 令牌桶限流器，同时限制最大并发请求数
+type rateLimiter struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+	blockUntil time.Time     //收到429后暂停请求直到该时间
+	inFlight   chan struct{} //并发令牌
+}
+
+func newRateLimiter(limit EndpointLimit) *rateLimiter {
+	burst := float64(limit.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	l := &rateLimiter{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+	if limit.MaxInFlight > 0 {
+		l.inFlight = make(chan struct{}, limit.MaxInFlight)
+	}
+	return l
+}
+
+//acquire 获取并发令牌和速率令牌，不足时阻塞等待
+func (l *rateLimiter) acquire() {
+	if l.inFlight != nil {
+		l.inFlight <- struct{}{}
+	}
+	for {
+		wait := l.reserve()
+		if wait <= 0 {
+			return
+		}
+		time.Sleep(wait)
+	}
+}
+
+//release 释放并发令牌
+func (l *rateLimiter) release() {
+	if l.inFlight != nil {
+		<-l.inFlight
+	}
+}
+
+//reserve 尝试取出一个速率令牌，返回需要等待的时间
+func (l *rateLimiter) reserve() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Before(l.blockUntil) {
+		return l.blockUntil.Sub(now)
+	}
+
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return 0
+	}
+	return time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+//backoff 暂停该接口的请求一段时间
+func (l *rateLimiter) backoff(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	until := time.Now().Add(d)
+	if until.After(l.blockUntil) {
+		l.blockUntil = until
+	}
+}
+
+//retryAfter 解析Retry-After头，支持秒数和HTTP日期
+func retryAfter(resp *http.Response, def time.Duration) time.Duration {
+	if resp == nil {
+		return def
+	}
+	v := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if v == "" {
+		return def
+	}
+	if sec, err := strconv.Atoi(v); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return def
+}
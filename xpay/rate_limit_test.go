@@ -0,0 +1,94 @@
+package xpay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseEndpointLimit(t *testing.T) {
+	prefix, limit, err := ParseEndpointLimit("coin/transaction:20:40:10")
+	if err != nil {
+		t.Errorf("ParseEndpointLimit failed, err: %v", err)
+		return
+	}
+	if prefix != "coin/transaction" || limit.Rate != 20 || limit.Burst != 40 || limit.MaxInFlight != 10 {
+		t.Errorf("ParseEndpointLimit unexpected result: %s %+v", prefix, limit)
+	}
+
+	if _, _, err := ParseEndpointLimit("coin/transaction:20"); err == nil {
+		t.Errorf("ParseEndpointLimit should fail on malformed input")
+	}
+}
+
+func TestClient_RetryAfter429(t *testing.T) {
+	var (
+		count  int32
+		always int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&count, 1) == 1 || atomic.LoadInt32(&always) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, false)
+	result, err := c.call("GET", "coin/blocks/latest", nil)
+	if err != nil {
+		t.Errorf("call failed, err: %v", err)
+		return
+	}
+	if result.Get("id").Uint() != 1 || atomic.LoadInt32(&count) != 2 {
+		t.Errorf("unexpected result: %s, requests: %d", result.Raw, count)
+	}
+
+	atomic.StoreInt32(&always, 1)
+	c.MaxRetries = 1
+	if _, err := c.call("GET", "coin/blocks/latest", nil); err == nil {
+		t.Errorf("call should fail when retries are exhausted")
+	}
+}
+
+func TestClient_MaxInFlight(t *testing.T) {
+	var (
+		current int32
+		peak    int32
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		atomic.AddInt32(&current, -1)
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	c := NewClient(server.URL, false)
+	c.SetRateLimit("coin/transaction", EndpointLimit{MaxInFlight: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.call("GET", "coin/transaction/abc", nil)
+		}()
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("max in flight exceeded: %d", peak)
+	}
+}
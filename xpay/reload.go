@@ -0,0 +1,136 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/astaxie/beego/config"
+)
+
+const defaultConfigWatchInterval = 10 * time.Second
+
+//ReloadAssetsConfig 运行中重新加载配置，不需要重启
+//新配置校验通过、API客户端创建完成后整体切换配置和客户端，扫描器的配置在下一次扫描开始时生效
+//配置无效或新的ServerAPI不可用时保持当前配置；Network和DataDir需要重启才能修改
+func (wm *WalletManager) ReloadAssetsConfig(c config.Configer) error {
+	wm.reloadMu.Lock()
+	defer wm.reloadMu.Unlock()
+
+	current := wm.CurrentConfig()
+	cfg, client, err := wm.newAssetsConfig(c)
+	if err == nil {
+		err = wm.checkReload(current, cfg, client)
+	}
+	if err != nil {
+		wm.Log.Errorf("reload asset config failed, keep current config, err: %v", err)
+		return err
+	}
+
+	cfg.inheritSenderStrategies(current)
+	wm.swapConfig(cfg, client)
+	wm.Log.Infof("asset config reloaded, ServerAPI: %s, FixFees: %s", cfg.ServerAPI, cfg.FixFees.String())
+	return nil
+}
+
+//checkReload 检查新配置能否在运行中替换当前配置
+func (wm *WalletManager) checkReload(current, cfg *WalletConfig, client *Client) error {
+	if cfg.Network != current.Network {
+		return fmt.Errorf("Network cannot be changed from %s to %s without restart", current.Network, cfg.Network)
+	}
+	if cfg.DataDir != current.DataDir {
+		return fmt.Errorf("DataDir cannot be changed from %q to %q without restart", current.DataDir, cfg.DataDir)
+	}
+	if cfg.BlockNotifyURL != current.BlockNotifyURL {
+		wm.Log.Std.Warning("BlockNotifyURL %s takes effect after block scanner restarts", cfg.BlockNotifyURL)
+	}
+
+	//切换API前确认新的API可用
+	if cfg.ServerAPI != current.ServerAPI {
+		if _, err := client.call("GET", "coin/blocks/latest", nil); err != nil {
+			return fmt.Errorf("new ServerAPI %s is unavailable: %v", cfg.ServerAPI, err)
+		}
+	}
+	return nil
+}
+
+//swapConfig 切换配置和客户端，不等待正在进行的扫描，扫描器在下一次扫描开始时应用新配置
+//缓存的手续费属于切换前的配置，下次查询时重新发现
+func (wm *WalletManager) swapConfig(cfg *WalletConfig, client *Client) {
+	wm.storeState(cfg, client)
+}
+
+//WatchAssetsConfig 监视配置文件，文件修改后自动重新加载，interval为检查间隔，0为默认10秒
+//重新加载失败时保持当前配置，文件再次修改后重试
+func (wm *WalletManager) WatchAssetsConfig(path string, interval time.Duration) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if interval <= 0 {
+		interval = defaultConfigWatchInterval
+	}
+
+	wm.watchMu.Lock()
+	defer wm.watchMu.Unlock()
+	if wm.watchQuit != nil {
+		close(wm.watchQuit)
+	}
+	wm.watchQuit = make(chan struct{})
+	go wm.watchConfig(path, interval, info, wm.watchQuit)
+	return nil
+}
+
+//StopWatchingAssetsConfig 停止监视配置文件
+func (wm *WalletManager) StopWatchingAssetsConfig() {
+	wm.watchMu.Lock()
+	defer wm.watchMu.Unlock()
+	if wm.watchQuit != nil {
+		close(wm.watchQuit)
+		wm.watchQuit = nil
+	}
+}
+
+func (wm *WalletManager) watchConfig(path string, interval time.Duration, last os.FileInfo, quit chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			wm.Log.Std.Warning("stat asset config %s failed, err: %v", path, err)
+			continue
+		}
+		if info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+			continue
+		}
+		last = info
+
+		c, err := config.NewConfig("ini", path)
+		if err != nil {
+			wm.Log.Errorf("parse asset config %s failed, keep current config, err: %v", path, err)
+			continue
+		}
+		wm.ReloadAssetsConfig(c)
+	}
+}
@@ -0,0 +1,231 @@
+package xpay
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/astaxie/beego/config"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//reloadConfig 新配置，沿用当前的DataDir和mockServer的校验区块
+func reloadConfig(t *testing.T, wm *WalletManager, ini string) config.Configer {
+	c, err := config.NewConfigData("ini", []byte("DataDir = "+wm.CurrentConfig().DataDir+"\n"+mockNetworkCheck+ini))
+	if err != nil {
+		t.Fatalf("NewConfigData failed, err: %v", err)
+	}
+	return c
+}
+
+func TestReloadAssetsConfig(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("a100")
+	other := newMockServer()
+	defer other.Close()
+	other.addBlock("b100")
+
+	wm := newMockWalletManager(t, server, "ScanWorkers = 2\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+
+	//切换API和手续费
+	if err := wm.ReloadAssetsConfig(reloadConfig(t, wm, "ServerAPI = "+other.URL+"\nFixFees = 0.02\nScanWorkers = 4\n")); err != nil {
+		t.Fatalf("ReloadAssetsConfig failed, err: %v", err)
+	}
+	//扫描器的配置在下一次扫描开始时应用
+	if wm.CurrentConfig().FixFees.String() != "0.02" || bs.applyConfig() != wm.CurrentConfig() || cap(bs.extractingCH) != 4 {
+		t.Errorf("reloaded config is not applied")
+	}
+	if block, err := wm.GetLatestBlock(); err != nil || block.Hash != "b100" {
+		t.Errorf("client is not switched to the new ServerAPI: %v, %v", block, err)
+	}
+
+	//无效配置保持当前配置
+	current := wm.CurrentConfig()
+	for _, ini := range []string{
+		"ServerAPI = " + other.URL + "\nFixFees = abc\n",
+		"ServerAPI = http://127.0.0.1:1/\nFixFees = 0.03\n",
+		"ServerAPI = " + other.URL + "\nFixFees = 0.03\nDataDir = " + newTempDir(t) + "\n",
+		"ServerAPI = " + other.URL + "\nFixFees = 0.03\nNetwork = testnet\n",
+		"ServerAPI = " + other.URL + "\nFixFees = 0.03\nNetworkCheckHeight = 100\nNetworkCheckHash = a100\n",
+	} {
+		if err := wm.ReloadAssetsConfig(reloadConfig(t, wm, ini)); err == nil {
+			t.Errorf("ReloadAssetsConfig(%q) should fail", ini)
+		}
+		if wm.CurrentConfig() != current {
+			t.Fatalf("ReloadAssetsConfig(%q) changed the config", ini)
+		}
+	}
+}
+
+func TestLoadAssetsConfig_Rollback(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.addBlock("h100")
+
+	wm := newMockWalletManager(t, server, "")
+	current := wm.CurrentConfig()
+	if wm.Config != current {
+		t.Fatalf("Config field is not the loaded config")
+	}
+
+	//本地区块损坏时加载失败，配置和客户端都不切换
+	dataDir := newTempDir(t)
+	blocksDir := filepath.Join(dataDir, current.localFile("blocks"))
+	os.MkdirAll(blocksDir, 0755)
+	ioutil.WriteFile(filepath.Join(blocksDir, "100.json"), []byte("{"), 0644)
+	c, _ := config.NewConfigData("ini", []byte("ServerAPI = "+server.URL+"\nFixFees = 0.05\nDataDir = "+dataDir+"\n"+mockNetworkCheck))
+	if err := wm.LoadAssetsConfig(c); err == nil || !strings.Contains(err.Error(), "open local blocks failed") {
+		t.Fatalf("LoadAssetsConfig with broken local blocks = %v, want failure", err)
+	}
+	if wm.CurrentConfig() != current || wm.Config != current {
+		t.Errorf("failed LoadAssetsConfig changed the config")
+	}
+
+	//加载成功后Config字段与CurrentConfig一致
+	os.RemoveAll(blocksDir)
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed, err: %v", err)
+	}
+	if wm.CurrentConfig().FixFees.String() != "0.05" || wm.Config != wm.CurrentConfig() {
+		t.Errorf("loaded config is not applied")
+	}
+}
+
+func TestReloadAssetsConfig_Scanning(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	for i := 0; i < 5; i++ {
+		server.addBlock(fmt.Sprintf("h%d", 100+i))
+	}
+
+	wm := newMockWalletManager(t, server, "ScanStartHeight = 100\nMaxBlocksPerScan = 1\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	bs.SetBlockchainDAI(newMockBlockchainDAI())
+	bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+		return "", false
+	})
+	bs.Scanning = true
+
+	//扫描、查询手续费和重新加载同时进行
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			bs.triggerScan()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			wm.Fees()
+			wm.CircuitStatus()
+		}
+	}()
+	for i := 0; i < 5; i++ {
+		if err := wm.ReloadAssetsConfig(reloadConfig(t, wm, "ServerAPI = "+server.URL+"\nFixFees = 0.0"+strconv.Itoa(i+1)+"\nScanStartHeight = 100\nMaxBlocksPerScan = 1\n")); err != nil {
+			t.Fatalf("ReloadAssetsConfig failed, err: %v", err)
+		}
+	}
+	wg.Wait()
+
+	if wm.CurrentConfig().FixFees.String() != "0.05" {
+		t.Errorf("FixFees = %s, want 0.05", wm.CurrentConfig().FixFees)
+	}
+	if height, _, _ := bs.GetLocalBlockHead(); height != 104 {
+		t.Errorf("local head = %d, want 104", height)
+	}
+}
+
+func TestWatchAssetsConfig(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	dataDir := newTempDir(t)
+	path := filepath.Join(dataDir, "XIF.ini")
+	write := func(ini string) {
+		if err := ioutil.WriteFile(path, []byte("ServerAPI = "+server.URL+"\nDataDir = "+dataDir+"\n"+mockNetworkCheck+ini), 0644); err != nil {
+			t.Fatalf("write config failed, err: %v", err)
+		}
+	}
+	write("FixFees = 0.01\n")
+	c, _ := config.NewConfig("ini", path)
+	wm := NewWalletManager()
+	if err := wm.LoadAssetsConfig(c); err != nil {
+		t.Fatalf("LoadAssetsConfig failed, err: %v", err)
+	}
+	if err := wm.WatchAssetsConfig(path, 10*time.Millisecond); err != nil {
+		t.Fatalf("WatchAssetsConfig failed, err: %v", err)
+	}
+	defer wm.StopWatchingAssetsConfig()
+
+	fixFees := func() string {
+		return wm.CurrentConfig().FixFees.String()
+	}
+	waitFees := func(want string) {
+		for i := 0; i < 200 && fixFees() != want; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if got := fixFees(); got != want {
+			t.Fatalf("FixFees = %s, want %s", got, want)
+		}
+	}
+
+	write("FixFees = 0.025\n")
+	waitFees("0.025")
+
+	//无效配置不生效，修正后重新加载
+	write("FixFees = 0.0x\n")
+	time.Sleep(100 * time.Millisecond)
+	waitFees("0.025")
+	write("FixFees = 0.0300\n")
+	waitFees("0.03")
+}
+
+func TestReloadAssetsConfig_SenderStrategy(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+
+	wm := newMockWalletManager(t, server, "SenderStrategy = roundrobin\nAccountSenderStrategies = acc2=lru\n")
+	a, b := testAddress("a"), testAddress("b")
+	candidates := []*SenderCandidate{{Account: &XIFAccount{Publickey: a}}, {Account: &XIFAccount{Publickey: b}}}
+	selected := func(accountID string) string {
+		if c := wm.senderStrategy(accountID).Select(accountID, candidates); c != nil {
+			return c.Account.Publickey
+		}
+		return ""
+	}
+	hot, _ := NewSenderStrategy("hot:" + b)
+	wm.SetSenderStrategy("acc3", hot)
+
+	if got := selected("acc1"); got != a {
+		t.Fatalf("roundrobin sender = %s, want %s", got, a)
+	}
+
+	//策略没有修改时保留轮询状态，设置的策略保留
+	if err := wm.ReloadAssetsConfig(reloadConfig(t, wm, "ServerAPI = "+server.URL+"\nFixFees = 0.02\nSenderStrategy = RoundRobin\nAccountSenderStrategies = acc2=lru\n")); err != nil {
+		t.Fatalf("ReloadAssetsConfig failed, err: %v", err)
+	}
+	if got := selected("acc1"); got != b {
+		t.Errorf("roundrobin sender after reload = %s, want %s", got, b)
+	}
+	if got := selected("acc3"); got != b {
+		t.Errorf("SetSenderStrategy is lost after reload: %s", got)
+	}
+
+	//策略修改后使用新的策略
+	if err := wm.ReloadAssetsConfig(reloadConfig(t, wm, "ServerAPI = "+server.URL+"\nFixFees = 0.02\nSenderStrategy = largest\nAccountSenderStrategies = acc2=lru\n")); err != nil {
+		t.Fatalf("ReloadAssetsConfig failed, err: %v", err)
+	}
+	if _, ok := wm.senderStrategy("acc1").(largestSender); !ok {
+		t.Errorf("changed strategy is not applied: %T", wm.senderStrategy("acc1"))
+	}
+}
@@ -2,7 +2,10 @@ package xpay
 
 import (
 	"fmt"
+	"net/http"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/imroc/req"
@@ -13,24 +16,54 @@ import (
 // request and responses. A Client must be configured with a secret token
 // to authenticate with other Cores on the network.
 type Client struct {
-	serverAPI string
-	Debug     bool
-	client    *req.Req
+	serverAPI  string
+	Debug      bool
+	client     *req.Req
+	MaxRetries int //遇到429时最大重试次数
+
+	limitMu      sync.RWMutex
+	defaultLimit *rateLimiter
+	limits       map[string]*rateLimiter //接口路径前缀对应的限流器
+
+	breaker *circuitBreaker //API熔断器
+	auth    *ClientAuth     //API认证
+
+	debugLog *DebugLogConfig //调试日志配置
 }
 
 // NewClient init a http client
 func NewClient(serverAPI string, debug bool) *Client {
+	c, _ := NewClientWithTransport(serverAPI, debug, nil)
+	return c
+}
+
+// NewClientWithTransport init a http client with transport config, nil transport uses default http client
+func NewClientWithTransport(serverAPI string, debug bool, transport *TransportConfig) (*Client, error) {
 
 	serverAPI = strings.TrimSuffix(serverAPI, "/")
 	c := Client{
-		serverAPI: serverAPI,
-		Debug:     debug,
+		serverAPI:    serverAPI,
+		Debug:        debug,
+		MaxRetries:   defaultRateLimitRetries,
+		defaultLimit: newRateLimiter(EndpointLimit{}),
+		limits:       make(map[string]*rateLimiter),
+		breaker:      newCircuitBreaker(defaultCircuitFailureThreshold, defaultCircuitOpenTimeout),
 	}
 
 	api := req.New()
+	if transport != nil {
+		httpClient, err := transport.NewHTTPClient()
+		if err != nil {
+			return nil, err
+		}
+		api.SetClient(httpClient)
+	} else {
+		//提前创建http.Client，避免并发请求时惰性初始化的数据竞争
+		api.Client()
+	}
 	c.client = api
 
-	return &c
+	return &c, nil
 }
 
 // Call calls a remote procedure on another node, specified by the path.
@@ -40,28 +73,163 @@ func (c *Client) call(method, path string, param interface{}) (*gjson.Result, er
 		return nil, fmt.Errorf("API url is not setup. ")
 	}
 
-	url := c.serverAPI + "/" + strings.TrimPrefix(path, "/")
+	path = strings.TrimPrefix(path, "/")
+	url := c.serverAPI + "/" + path
+	limiter := c.limiter(path)
 
-	r, err := c.client.Do(method, url, param)
+	//自行编码参数，签名的内容与发送的一致
+	body := encodeParam(param)
+	if body != "" && (method == "GET" || method == "HEAD") {
+		url = url + "?" + body
+		body = ""
+	}
 
-	if c.Debug {
-		log.Std.Info("Request API Completed")
+	//熔断中，快速失败
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	var (
+		r     *req.Resp
+		err   error
+		start = time.Now()
+	)
+	for i := 0; ; i++ {
+		//每次重试重新签名，保证时间戳有效
+		header := c.auth.header(method, url, body, time.Now())
+		args := []interface{}{header}
+		if body != "" {
+			header["Content-Type"] = "application/x-www-form-urlencoded; charset=UTF-8"
+			args = append(args, body)
+		}
+
+		limiter.acquire()
+		r, err = c.client.Do(method, url, args...)
+		limiter.release()
+		if err != nil || r.Response().StatusCode != http.StatusTooManyRequests {
+			break
+		}
+
+		//被服务端限流，按Retry-After暂停该接口的请求
+		wait := retryAfter(r.Response(), defaultRetryAfter)
+		limiter.backoff(wait)
+		log.Std.Warning("API %s is rate limited, retry after %v", path, wait)
+		if i >= c.MaxRetries {
+			err = fmt.Errorf("API %s is rate limited, retry after %v", path, wait)
+			break
+		}
 	}
 
+	//网络错误和服务端5xx错误计入熔断，被限流不计入
+	if err != nil && r == nil {
+		c.breaker.done(err)
+	} else if status := r.Response().StatusCode; status >= http.StatusInternalServerError {
+		c.breaker.done(fmt.Errorf("API %s responded %d", path, status))
+	} else {
+		c.breaker.done(nil)
+	}
+
+	var resp gjson.Result
+	if err == nil {
+		resp = gjson.ParseBytes(r.Bytes())
+		err = isError(&resp)
+	}
+
+	//结构化输出请求日志，不输出请求头，敏感字段脱敏
 	if c.Debug {
-		log.Std.Info("%+v", r)
+		c.logRequest(method, path, param, r, err, time.Since(start))
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+//stream 建立长连接GET请求，用于订阅推送，rawurl为相对路径时基于serverAPI
+func (c *Client) stream(rawurl string) (*http.Response, error) {
+
+	if c.client == nil {
+		return nil, fmt.Errorf("API url is not setup. ")
+	}
+
+	if !strings.HasPrefix(rawurl, "http://") && !strings.HasPrefix(rawurl, "https://") {
+		rawurl = c.serverAPI + "/" + strings.TrimPrefix(rawurl, "/")
 	}
 
+	request, err := http.NewRequest("GET", rawurl, nil)
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range c.auth.header("GET", rawurl, "", time.Now()) {
+		request.Header.Set(k, v)
+	}
+	request.Header.Set("Accept", "text/event-stream")
+
+	//长连接不能设置整体请求超时
+	httpClient := *c.client.Client()
+	httpClient.Timeout = 0
 
-	resp := gjson.ParseBytes(r.Bytes())
-	err = isError(&resp)
+	resp, err := httpClient.Do(request)
 	if err != nil {
 		return nil, err
 	}
-	return &resp, nil
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("subscribe %s responded %d", rawurl, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+//SetRateLimit 设置接口限流，prefix为接口路径前缀，为空时设置默认限流
+func (c *Client) SetRateLimit(prefix string, limit EndpointLimit) {
+	c.limitMu.Lock()
+	defer c.limitMu.Unlock()
+
+	prefix = strings.Trim(prefix, "/")
+	if prefix == "" {
+		c.defaultLimit = newRateLimiter(limit)
+		return
+	}
+	c.limits[prefix] = newRateLimiter(limit)
+}
+
+//SetCircuitBreaker 设置熔断器，threshold为连续失败次数阈值，0为不启用
+func (c *Client) SetCircuitBreaker(threshold int, openTimeout time.Duration) {
+	c.breaker = newCircuitBreaker(threshold, openTimeout)
+}
+
+//CircuitStatus 熔断器状态
+func (c *Client) CircuitStatus() CircuitStatus {
+	return c.breaker.status()
+}
+
+//SetDebugLog 设置调试日志的脱敏字段和采样率
+func (c *Client) SetDebugLog(debugLog *DebugLogConfig) {
+	c.debugLog = debugLog
+}
+
+//SetAuth 设置API认证
+func (c *Client) SetAuth(auth *ClientAuth) {
+	c.auth = auth
+}
+
+//limiter 查找路径匹配的限流器，最长前缀优先
+func (c *Client) limiter(path string) *rateLimiter {
+	c.limitMu.RLock()
+	defer c.limitMu.RUnlock()
+
+	var (
+		matched string
+		limiter = c.defaultLimit
+	)
+	for prefix, l := range c.limits {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+			limiter = l
+		}
+	}
+	return limiter
 }
 
 //isError 是否报错
@@ -0,0 +1,279 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	SenderStrategyFirst      = "first"      //地址列表中第一个余额足够的地址
+	SenderStrategyLargest    = "largest"    //余额最多的地址
+	SenderStrategySmallest   = "smallest"   //余额足够的地址中余额最少的，优先消耗零散余额
+	SenderStrategyRoundRobin = "roundrobin" //轮流使用余额足够的地址
+	SenderStrategyLRU        = "lru"        //最久未使用的地址
+	SenderStrategyHot        = "hot"        //指定的热钱包地址，格式：hot:地址
+)
+
+const defaultBalanceWorkers = 10
+
+//SenderCandidate 余额足够出账的地址
+type SenderCandidate struct {
+	Account *XIFAccount
+	Balance decimal.Decimal //可出账余额，资产转账时为资产余额
+}
+
+//SenderStrategy 出账地址选择策略
+type SenderStrategy interface {
+	//Select 从候选地址中选择出账地址，候选地址按地址列表顺序，返回nil表示没有合适的地址
+	Select(accountID string, candidates []*SenderCandidate) *SenderCandidate
+}
+
+//NewSenderStrategy 根据名称创建内置的选择策略
+func NewSenderStrategy(name string) (SenderStrategy, error) {
+	name = strings.TrimSpace(name)
+	switch strings.ToLower(name) {
+	case "", SenderStrategyFirst:
+		return firstSender{}, nil
+	case SenderStrategyLargest:
+		return largestSender{}, nil
+	case SenderStrategySmallest:
+		return smallestSender{}, nil
+	case SenderStrategyRoundRobin:
+		return &roundRobinSender{next: make(map[string]int)}, nil
+	case SenderStrategyLRU:
+		return &lruSender{used: make(map[string]time.Time)}, nil
+	}
+	if strings.HasPrefix(name, SenderStrategyHot+":") {
+		address := strings.TrimSpace(strings.TrimPrefix(name, SenderStrategyHot+":"))
+		if address == "" {
+			return nil, fmt.Errorf("hot address of sender strategy is empty")
+		}
+		//账户地址为小写，热钱包地址校验后统一为小写再比较
+		address, err := ValidateAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hot address of sender strategy: %v", err)
+		}
+		return hotSender{address: address}, nil
+	}
+	return nil, fmt.Errorf("unsupported sender strategy: %s", name)
+}
+
+//ParseAccountSenderStrategy 解析账户的选择策略配置，格式：账户ID=策略
+func ParseAccountSenderStrategy(s string) (string, SenderStrategy, error) {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return "", nil, fmt.Errorf("invalid account sender strategy: %s", s)
+	}
+	strategy, err := NewSenderStrategy(s[i+1:])
+	if err != nil {
+		return "", nil, err
+	}
+	return strings.TrimSpace(s[:i]), strategy, nil
+}
+
+type firstSender struct{}
+
+func (firstSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+	return candidates[0]
+}
+
+type largestSender struct{}
+
+func (largestSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	var found *SenderCandidate
+	for _, c := range candidates {
+		if found == nil || c.Balance.GreaterThan(found.Balance) {
+			found = c
+		}
+	}
+	return found
+}
+
+type smallestSender struct{}
+
+func (smallestSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	var found *SenderCandidate
+	for _, c := range candidates {
+		if found == nil || c.Balance.LessThan(found.Balance) {
+			found = c
+		}
+	}
+	return found
+}
+
+type roundRobinSender struct {
+	mu   sync.Mutex
+	next map[string]int
+}
+
+func (s *roundRobinSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.next[accountID] % len(candidates)
+	s.next[accountID] = i + 1
+	return candidates[i]
+}
+
+type lruSender struct {
+	mu   sync.Mutex
+	used map[string]time.Time
+}
+
+func (s *lruSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var found *SenderCandidate
+	for _, c := range candidates {
+		if found == nil || s.used[c.Account.Publickey].Before(s.used[found.Account.Publickey]) {
+			found = c
+		}
+	}
+	if found != nil {
+		s.used[found.Account.Publickey] = time.Now()
+	}
+	return found
+}
+
+type hotSender struct {
+	address string
+}
+
+func (s hotSender) Select(accountID string, candidates []*SenderCandidate) *SenderCandidate {
+	for _, c := range candidates {
+		if c.Account.Publickey == s.address {
+			return c
+		}
+	}
+	return nil
+}
+
+//SetSenderStrategy 设置账户的出账地址选择策略，accountID为空时设置默认策略
+//设置的策略优先于配置文件中同一范围的策略，重新加载配置后仍然有效
+func (wm *WalletManager) SetSenderStrategy(accountID string, strategy SenderStrategy) {
+	wm.senderMu.Lock()
+	defer wm.senderMu.Unlock()
+	if accountID == "" {
+		wm.senderDefault = strategy
+		return
+	}
+	if wm.senderAccounts == nil {
+		wm.senderAccounts = make(map[string]SenderStrategy)
+	}
+	wm.senderAccounts[accountID] = strategy
+}
+
+//senderStrategy 账户的出账地址选择策略：设置的账户策略、配置的账户策略、设置的默认策略、配置的默认策略
+func (wm *WalletManager) senderStrategy(accountID string) SenderStrategy {
+	wm.senderMu.RLock()
+	defer wm.senderMu.RUnlock()
+	if strategy, ok := wm.senderAccounts[accountID]; ok {
+		return strategy
+	}
+	cfg := wm.CurrentConfig()
+	if strategy, ok := cfg.AccountSenderStrategies[accountID]; ok {
+		return strategy
+	}
+	if wm.senderDefault != nil {
+		return wm.senderDefault
+	}
+	if cfg.SenderStrategy != nil {
+		return cfg.SenderStrategy
+	}
+	return firstSender{}
+}
+
+//inheritSenderStrategies 重新加载配置时，策略配置没有变化的沿用当前的策略，保留轮询和最近使用的状态
+func (c *WalletConfig) inheritSenderStrategies(current *WalletConfig) {
+	if spec, ok := c.senderStrategySpecs[""]; ok && spec == current.senderStrategySpecs[""] {
+		c.SenderStrategy = current.SenderStrategy
+	}
+	for accountID := range c.AccountSenderStrategies {
+		if spec, ok := c.senderStrategySpecs[accountID]; ok && spec == current.senderStrategySpecs[accountID] {
+			if strategy, exist := current.AccountSenderStrategies[accountID]; exist {
+				c.AccountSenderStrategies[accountID] = strategy
+			}
+		}
+	}
+}
+
+//addressBalance 地址的主币和资产余额
+type addressBalance struct {
+	account      *XIFAccount
+	balance      decimal.Decimal
+	tokenBalance decimal.Decimal
+	err          error
+}
+
+//getAddressBalances 并发查询地址余额，结果与地址顺序一致，symbol不为空时同时查询资产余额
+func (wm *WalletManager) getAddressBalances(addresses []string, symbol string) []*addressBalance {
+
+	workers := wm.CurrentConfig().BalanceWorkers
+	if workers <= 0 {
+		workers = defaultBalanceWorkers
+	}
+
+	var (
+		results = make([]*addressBalance, len(addresses))
+		sem     = make(chan struct{}, workers)
+		wg      sync.WaitGroup
+	)
+
+	for i, address := range addresses {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, address string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			result := &addressBalance{}
+			results[i] = result
+
+			result.account, result.err = wm.GetWalletDetails(address)
+			if result.err != nil {
+				return
+			}
+			result.balance, _ = decimal.NewFromString(result.account.Amount)
+
+			if symbol == "" {
+				return
+			}
+			token, err := wm.GetTokenDetails(address, symbol)
+			if err != nil {
+				result.err = err
+				return
+			}
+			result.tokenBalance, _ = decimal.NewFromString(token.Amount)
+		}(i, address)
+	}
+
+	wg.Wait()
+
+	return results
+}
@@ -0,0 +1,100 @@
+package xpay
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+	"github.com/shopspring/decimal"
+)
+
+func TestSenderStrategy_Select(t *testing.T) {
+	a, b, c := testAddress("a"), testAddress("b"), testAddress("c")
+	candidates := make([]*SenderCandidate, 0)
+	for _, cand := range []struct{ address, balance string }{{a, "5"}, {b, "9"}, {c, "2"}} {
+		candidates = append(candidates, &SenderCandidate{
+			Account: &XIFAccount{Publickey: cand.address},
+			Balance: decimal.RequireFromString(cand.balance),
+		})
+	}
+
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{SenderStrategyFirst, []string{a, a}},
+		{SenderStrategyLargest, []string{b, b}},
+		{SenderStrategySmallest, []string{c, c}},
+		{SenderStrategyRoundRobin, []string{a, b, c, a}},
+		{SenderStrategyLRU, []string{a, b, c, a}},
+		{"hot:" + b, []string{b, b}},
+		{"hot:" + strings.ToUpper(b), []string{b, b}},
+		{"hot:" + testAddress("x"), []string{""}},
+	}
+	for _, test := range tests {
+		strategy, err := NewSenderStrategy(test.strategy)
+		if err != nil {
+			t.Fatalf("NewSenderStrategy(%s) failed, err: %v", test.strategy, err)
+		}
+		for i, want := range test.want {
+			got := ""
+			if selected := strategy.Select("acc1", candidates); selected != nil {
+				got = selected.Account.Publickey
+			}
+			if got != want {
+				t.Errorf("%s select #%d = %s, want %s", test.strategy, i, got, want)
+			}
+		}
+	}
+
+	if _, err := NewSenderStrategy("random"); err == nil {
+		t.Errorf("unsupported strategy should fail")
+	}
+	for _, name := range []string{"hot:", "hot:addr4", "hot:" + b[:64]} {
+		if _, err := NewSenderStrategy(name); err == nil {
+			t.Errorf("NewSenderStrategy(%s) should fail", name)
+		}
+	}
+	if _, _, err := ParseAccountSenderStrategy("largest"); err == nil {
+		t.Errorf("strategy without account should fail")
+	}
+}
+
+func TestTransactionDecoder_SenderStrategy(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	server.setAccount("addr1", Symbol, "0.05", 0)
+	server.setAccount("addr2", Symbol, "3", 0)
+	server.setAccount("addr3", Symbol, "1", 0)
+	hot := testAddress("addr4")
+	server.setAccount(hot, Symbol, "2", 0)
+
+	wm := newMockWalletManager(t, server, "SenderStrategy = smallest\nAccountSenderStrategies = acc2=largest\nBalanceWorkers = 2\n")
+	decoder := wm.GetTransactionDecoder()
+	sender := func(accountID string) string {
+		wrapper := newMockWalletDAI(accountID, "addr1", "addr2", "addr3", hot, "addr5")
+		rawTx := &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: Symbol},
+			Account: &openwallet.AssetsAccount{AccountID: accountID},
+			To:      map[string]string{testAddress("dest"): "0.5"},
+		}
+		if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+			t.Fatalf("CreateRawTransaction failed, err: %v", err)
+		}
+		txs, _ := DecodeRawTransactions(rawTx.RawHex)
+		return txs[0].Sender
+	}
+
+	if got := sender("acc1"); got != "addr3" {
+		t.Errorf("default strategy sender = %s, want addr3", got)
+	}
+	if got := sender("acc2"); got != "addr2" {
+		t.Errorf("account strategy sender = %s, want addr2", got)
+	}
+
+	strategy, _ := NewSenderStrategy("hot:" + strings.ToUpper(hot))
+	wm.SetSenderStrategy("acc3", strategy)
+	if got := sender("acc3"); got != hot {
+		t.Errorf("hot strategy sender = %s, want %s", got, hot)
+	}
+}
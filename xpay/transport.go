@@ -0,0 +1,123 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+//TransportConfig HTTP传输配置
+type TransportConfig struct {
+	CAFile             string //自定义CA证书，PEM格式
+	CertFile           string //客户端证书，用于mTLS
+	KeyFile            string //客户端私钥，用于mTLS
+	InsecureSkipVerify bool   //不校验服务端证书，仅用于测试
+	//代理地址，支持http://、https://、socks5://，为空时读取环境变量HTTP_PROXY/HTTPS_PROXY
+	Proxy string
+
+	MaxIdleConns          int           //最大空闲连接数
+	MaxIdleConnsPerHost   int           //每个主机最大空闲连接数
+	MaxConnsPerHost       int           //每个主机最大连接数，0为不限制
+	IdleConnTimeout       time.Duration //空闲连接超时
+	KeepAlive             time.Duration //TCP keep-alive间隔
+	DialTimeout           time.Duration //建立连接超时
+	TLSHandshakeTimeout   time.Duration //TLS握手超时
+	ResponseHeaderTimeout time.Duration //等待响应头超时，0为不限制
+	RequestTimeout        time.Duration //整个请求超时
+}
+
+//NewTransportConfig 默认传输配置
+func NewTransportConfig() *TransportConfig {
+	return &TransportConfig{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		KeepAlive:           30 * time.Second,
+		DialTimeout:         30 * time.Second,
+		TLSHandshakeTimeout: 10 * time.Second,
+		RequestTimeout:      2 * time.Minute,
+	}
+}
+
+//NewHTTPClient 根据配置创建http.Client
+func (tc *TransportConfig) NewHTTPClient() (*http.Client, error) {
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: tc.InsecureSkipVerify,
+	}
+
+	if tc.CAFile != "" {
+		pem, err := ioutil.ReadFile(tc.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read CA file failed, err: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA file %s contains no valid certificate", tc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if tc.CertFile != "" || tc.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(tc.CertFile, tc.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate failed, err: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if tc.Proxy != "" {
+		proxyURL, err := url.Parse(tc.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %s", tc.Proxy)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport := &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   tc.DialTimeout,
+			KeepAlive: tc.KeepAlive,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxIdleConnsPerHost:   tc.MaxIdleConnsPerHost,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		IdleConnTimeout:       tc.IdleConnTimeout,
+		TLSHandshakeTimeout:   tc.TLSHandshakeTimeout,
+		ResponseHeaderTimeout: tc.ResponseHeaderTimeout,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   tc.RequestTimeout,
+	}, nil
+}
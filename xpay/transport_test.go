@@ -0,0 +1,58 @@
+package xpay
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewClientWithTransport_CAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "xpay")
+	if err != nil {
+		t.Errorf("TempDir failed, err: %v", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	caFile := filepath.Join(dir, "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	ioutil.WriteFile(caFile, caPEM, 0600)
+
+	//未配置CA，证书校验失败
+	c, err := NewClientWithTransport(server.URL, false, NewTransportConfig())
+	if err != nil {
+		t.Errorf("NewClientWithTransport failed, err: %v", err)
+		return
+	}
+	if _, err := c.call("GET", "coin/blocks/latest", nil); err == nil {
+		t.Errorf("call should fail without CA")
+	}
+
+	tc := NewTransportConfig()
+	tc.CAFile = caFile
+	c, err = NewClientWithTransport(server.URL, false, tc)
+	if err != nil {
+		t.Errorf("NewClientWithTransport failed, err: %v", err)
+		return
+	}
+	if _, err := c.call("GET", "coin/blocks/latest", nil); err != nil {
+		t.Errorf("call failed, err: %v", err)
+	}
+}
+
+func TestNewClientWithTransport_InvalidProxy(t *testing.T) {
+	tc := NewTransportConfig()
+	tc.Proxy = "ftp://127.0.0.1:21"
+	if _, err := NewClientWithTransport("http://127.0.0.1", false, tc); err == nil {
+		t.Errorf("NewClientWithTransport should fail on unsupported proxy")
+	}
+}
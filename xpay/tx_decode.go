@@ -17,11 +17,13 @@ package xpay
 
 import (
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"github.com/blocktree/go-owcrypt"
 	"github.com/blocktree/openwallet/v2/openwallet"
 	"github.com/shopspring/decimal"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -43,6 +45,7 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 	var (
 		accountID       = rawTx.Account.AccountID
 		findAddrBalance *XIFAccount
+		sources         = make([]*fundingSource, 0)
 	)
 
 	//获取wallet
@@ -55,33 +58,112 @@ func (decoder *TransactionDecoder) CreateRawTransaction(wrapper openwallet.Walle
 		return openwallet.Errorf(openwallet.ErrAccountNotAddress, "[%s] have not addresses", accountID)
 	}
 
-	var amountStr string
-	for _, v := range rawTx.To {
-		amountStr = v
-		break
+	if len(rawTx.To) == 0 {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "receiver addresses is empty")
 	}
 
-	amount, _ := decimal.NewFromString(amountStr)
+	//XIF只支持单个接收者，多个接收者拆分为同一发送者连续nonce的多笔交易，余额需足够支付全部数量和手续费
+	amount := decimal.Zero
+	receivers := make(map[string]string, len(rawTx.To))
+	for to, v := range rawTx.To {
+		address, err := ValidateAddress(to)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "invalid receiver address: %v", err)
+		}
+		if _, exist := receivers[address]; exist {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "duplicate receiver address [%s]", address)
+		}
+		amountDec, err := ParseTransferAmount(v)
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid amount [%s] of receiver [%s]: %v", v, to, err)
+		}
+		receivers[address] = v
+		amount = amount.Add(amountDec.Decimal())
+	}
+	//接收地址统一为小写
+	rawTx.To = receivers
+	fixFees := decoder.wm.Fees()
+	fees := fixFees.Mul(decimal.New(int64(len(rawTx.To)), 0))
+
+	mode, err := decoder.fundingMode(rawTx)
+	if err != nil {
+		return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
 
+	if _, err := decoder.memo(rawTx); err != nil {
+		return err
+	}
+
+	symbol := ""
+	if rawTx.Coin.IsContract {
+		symbol = coinSymbol(rawTx.Coin)
+	}
+	list := make([]string, 0, len(addresses))
 	for _, addr := range addresses {
+		list = append(list, addr.Address)
+	}
 
-		addrBalance, err := decoder.wm.GetWalletDetails(addr.Address)
-		if err != nil {
+	candidates := make([]*SenderCandidate, 0)
+	for _, addrBalance := range decoder.wm.getAddressBalances(list, symbol) {
+
+		if addrBalance.err != nil {
+			//API不可用，无法创建交易单
+			if IsBackendUnavailable(addrBalance.err) {
+				return openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "%v", addrBalance.err)
+			}
 			continue
 		}
 
-		balance, _ := decimal.NewFromString(addrBalance.Amount)
+		balance := addrBalance.balance
+
+		if rawTx.Coin.IsContract {
+			//资产转账，主币只需足够支付手续费
+			if balance.LessThan(fixFees) {
+				continue
+			}
+			tokenAmount := addrBalance.tokenBalance
+			if balance.GreaterThanOrEqual(fees) && tokenAmount.GreaterThanOrEqual(amount) {
+				candidates = append(candidates, &SenderCandidate{Account: addrBalance.account, Balance: tokenAmount})
+				continue
+			}
+			if tokenAmount.GreaterThan(decimal.Zero) {
+				sources = append(sources, &fundingSource{account: addrBalance.account, available: tokenAmount})
+			}
+			continue
+		}
 
-		//余额不足查找下一个地址
-		totalSend := amount.Add(decoder.wm.Config.FixFees)
+		//余额足够的地址作为候选
+		totalSend := amount.Add(fees)
 		if balance.GreaterThanOrEqual(totalSend) {
-			//只要找到一个合适使用的地址余额就停止遍历
-			findAddrBalance = addrBalance
-			break
+			candidates = append(candidates, &SenderCandidate{Account: addrBalance.account, Balance: balance})
+			continue
+		}
+
+		//扣除一笔手续费后的余额可用于多地址出账
+		if available := balance.Sub(fixFees); available.GreaterThan(decimal.Zero) {
+			sources = append(sources, &fundingSource{account: addrBalance.account, available: available})
+		}
+	}
+
+	//按账户的策略选择出账地址
+	if selected := decoder.wm.senderStrategy(accountID).Select(accountID, candidates); selected != nil {
+		findAddrBalance = selected.Account
+	}
+
+	//没有单个地址足够时，按出账模式由多个地址出账，只支持单个接收者
+	if findAddrBalance == nil && mode != FundingModeSingle && len(rawTx.To) == 1 {
+		for destination := range rawTx.To {
+			plan := newFundingPlan(mode, sources, destination, amount, fixFees)
+			if plan != nil {
+				return decoder.createFundingRawTransaction(wrapper, rawTx, plan, sources)
+			}
 		}
 	}
 
 	if findAddrBalance == nil {
+		if rawTx.Coin.IsContract {
+			return openwallet.Errorf(openwallet.ErrInsufficientTokenBalanceOfAddress, "all address's token balance of account is not enough")
+		}
 		return openwallet.Errorf(openwallet.ErrInsufficientBalanceOfAccount, "all address's balance of account is not enough")
 	}
 
@@ -128,7 +210,7 @@ func (decoder *TransactionDecoder) SignRawTransaction(wrapper openwallet.WalletD
 				return fmt.Errorf("decoder transaction hash failed, unexpected err: %v", err)
 			}
 
-			//msg := append([]byte(decoder.wm.Config.NetworkID), hash...)
+			//msg := append([]byte(decoder.wm.CurrentConfig().NetworkID), hash...)
 			sig, _, ret := owcrypt.Signature(keyBytes, nil, msg, keySignature.EccType)
 			if ret != owcrypt.SUCCESS {
 				return fmt.Errorf("sign transaction hash failed, unexpected err: %v", err)
@@ -158,15 +240,15 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 		return fmt.Errorf("transaction signature is empty")
 	}
 
-	txHex, err := hex.DecodeString(rawTx.RawHex)
+	txs, err := DecodeRawTransactions(rawTx.RawHex)
 	if err != nil {
-		return fmt.Errorf("transaction decode failed, unexpected error: %v", err)
+		return err
 	}
 
-	var tx RawTransaction
-	err = json.Unmarshal(txHex, &tx)
-	if err != nil {
-		return err
+	//按签名消息匹配交易单
+	txByMsg := make(map[string]*RawTransaction, len(txs))
+	for _, tx := range txs {
+		txByMsg[hex.EncodeToString(tx.Hash(decoder.wm.CurrentConfig().SignNotes))] = tx
 	}
 
 	//支持多重签名
@@ -181,6 +263,11 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 			//decoder.wm.Log.Debug("txHex:", hex.EncodeToString(txHex))
 			//decoder.wm.Log.Debug("Signature:", keySignature.Signature)
 
+			tx, ok := txByMsg[keySignature.Message]
+			if !ok {
+				return fmt.Errorf("transaction of signature message [%s] not found", keySignature.Message)
+			}
+
 			dePub := owcrypt.PointDecompress(publicKey, keySignature.EccType)
 			//验证签名
 			ret := owcrypt.Verify(dePub[1:], nil, messsage, signature, keySignature.EccType)
@@ -192,44 +279,104 @@ func (decoder *TransactionDecoder) VerifyRawTransaction(wrapper openwallet.Walle
 			if err != nil {
 				return err
 			}
+		}
+	}
 
-			txJson, _ := json.Marshal(tx)
-			rawTx.RawHex = hex.EncodeToString(txJson)
-			rawTx.IsCompleted = true
-
+	//所有交易都完成签名
+	for _, tx := range txs {
+		if tx.Signature == "" {
+			return fmt.Errorf("transaction of nonce [%d] is not signed", tx.Nonce)
 		}
 	}
 
+	rawTx.RawHex = EncodeRawTransactions(txs)
+	rawTx.IsCompleted = true
+
 	return nil
 }
 
+//ErrSubmitRawTransactionPartial 多笔交易只有部分广播成功
+//已广播的txid记录在交易单和返回交易的扩展参数txids，再次提交同一个交易单时从失败的交易继续，不会重复广播
+const ErrSubmitRawTransactionPartial = 2101
+
 //SendRawTransaction 广播交易单
+//多笔交易部分广播成功时，同时返回已广播部分的交易记录和ErrSubmitRawTransactionPartial错误
 func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.WalletDAI, rawTx *openwallet.RawTransaction) (*openwallet.Transaction, error) {
 
-	txHex, err := hex.DecodeString(rawTx.RawHex)
+	txs, err := DecodeRawTransactions(rawTx.RawHex)
 	if err != nil {
-		return nil, fmt.Errorf("transaction decode failed, unexpected error: %v", err)
+		return nil, err
 	}
 
-	var txSigned RawTransaction
-	err = json.Unmarshal(txHex, &txSigned)
-	if err != nil {
-		return nil, err
+	//再次提交时跳过已广播的交易
+	txids := make([]string, 0, len(txs))
+	if len(rawTx.ExtParam) > 0 {
+		for _, txid := range rawTx.GetExtParam().Get("txids").Array() {
+			txids = append(txids, txid.String())
+		}
+	}
+	if len(txids) > len(txs) {
+		return nil, fmt.Errorf("transaction has %d submitted txids but only %d transactions", len(txids), len(txs))
+	}
+	submitted := make(map[string]bool)
+	for _, txSigned := range txs[:len(txids)] {
+		submitted[txSigned.Sender] = true
 	}
+	feesSupport := feesSupportAddresses(rawTx)
+
+	//按nonce顺序广播，失败时停止，后续交易的nonce无法生效
+	for i := len(txids); i < len(txs); i++ {
+		txSigned := txs[i]
+
+		//归集交易确认后才广播出账交易，节点按已确认余额校验时，提前广播的出账交易会失败
+		if unconfirmed := decoder.unconfirmedConsolidations(rawTx, i, txids); len(unconfirmed) > 0 {
+			decoder.wm.Log.Infof("payout [%d/%d] waits for consolidation transactions %s to be confirmed", i+1, len(txs), strings.Join(unconfirmed, ","))
+			return decoder.submittedTransaction(rawTx, txids, len(txs)), openwallet.Errorf(ErrSubmitRawTransactionPending,
+				"payout waits for consolidation transactions %s to be confirmed, submit the same transaction again after they are confirmed",
+				strings.Join(unconfirmed, ","))
+		}
 
-	txid, err := decoder.wm.Sendraw(&txSigned)
-	if err != nil {
-		decoder.wm.UpdateAddressNonce(wrapper, txSigned.Sender, 0)
-		return nil, err
+		txid, err := decoder.wm.Sendraw(txSigned)
+		if err != nil {
+			//发送者没有已广播的交易，重置缓存的nonce，否则保留已使用的nonce
+			if !submitted[txSigned.Sender] {
+				decoder.wm.UpdateAddressNonce(wrapper, txSigned.Sender, 0)
+			}
+			if len(txids) == 0 {
+				return nil, err
+			}
+			return decoder.submittedTransaction(rawTx, txids, len(txs)), openwallet.Errorf(ErrSubmitRawTransactionPartial,
+				"transaction [%d/%d] submit failed, submitted txids: %s, submit the same transaction again to continue, err: %v",
+				i+1, len(txs), strings.Join(txids, ","), err)
+		}
+
+		//交易成功，地址nonce+1并记录到缓存
+		decoder.wm.UpdateAddressNonce(wrapper, txSigned.Sender, txSigned.Nonce)
+		submitted[txSigned.Sender] = true
+
+		//手续费补充已广播，确认前不再重复补充
+		if feesSupport[txSigned.Recipient] {
+			decoder.setFeesSupportPending(wrapper, txSigned.Recipient, time.Now().Unix())
+		}
+
+		decoder.wm.Log.Infof("Transaction [%s] submitted to the network successfully.", txid)
+
+		txids = append(txids, txid)
 	}
 
-	//交易成功，地址nonce+1并记录到缓存
-	decoder.wm.UpdateAddressNonce(wrapper, txSigned.Sender, txSigned.Nonce)
+	return decoder.submittedTransaction(rawTx, txids, len(txs)), nil
+}
 
-	decoder.wm.Log.Infof("Transaction [%s] submitted to the network successfully.", txid)
+//submittedTransaction 记录已广播的交易，total为交易单中的交易数量
+//多笔交易以第一笔交易的txid为准，全部txid记录在扩展参数txids；部分广播时扩展参数pending为未广播的数量
+func (decoder *TransactionDecoder) submittedTransaction(rawTx *openwallet.RawTransaction, txids []string, total int) *openwallet.Transaction {
 
-	rawTx.TxID = txid
-	rawTx.IsSubmit = true
+	rawTx.TxID = txids[0]
+	if len(txids) > 1 || len(txids) < total {
+		rawTx.SetExtParam("txids", txids)
+	}
+	rawTx.SetExtParam("pending", total-len(txids))
+	rawTx.IsSubmit = len(txids) == total
 
 	decimals := decoder.wm.Decimal()
 
@@ -246,94 +393,44 @@ func (decoder *TransactionDecoder) SubmitRawTransaction(wrapper openwallet.Walle
 		SubmitTime: time.Now().Unix(),
 	}
 
+	if len(txids) > 1 || len(txids) < total {
+		tx.SetExtParam("txids", txids)
+	}
+	if len(txids) < total {
+		tx.SetExtParam("pending", total-len(txids))
+	}
+
 	tx.WxID = openwallet.GenTransactionWxID(tx)
 
-	return tx, nil
+	return tx
 }
 
 //GetRawTransactionFeeRate 获取交易单的费率
 func (decoder *TransactionDecoder) GetRawTransactionFeeRate() (feeRate string, unit string, err error) {
-	return decoder.wm.Config.FixFees.String(), "TX", nil
+	return decoder.wm.Fees().String(), "TX", nil
 }
 
-//CreateSummaryRawTransaction 创建汇总交易
+//CreateSummaryRawTransaction 创建汇总交易，单个地址创建失败不影响其他地址，失败原因记录到日志
 func (decoder *TransactionDecoder) CreateSummaryRawTransaction(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransaction, error) {
 
-	var (
-		rawTxArray         = make([]*openwallet.RawTransaction, 0)
-		accountID          = sumRawTx.Account.AccountID
-		minTransfer, _     = decimal.NewFromString(sumRawTx.MinTransfer)
-		retainedBalance, _ = decimal.NewFromString(sumRawTx.RetainedBalance)
-	)
-
-	if minTransfer.Cmp(retainedBalance) < 0 {
-		return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
-	}
-
-	//获取wallet
-	addresses, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit,
-		"AccountID", sumRawTx.Account.AccountID)
+	rawTxWithErrArray, err := decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(addresses) == 0 {
-		return nil, fmt.Errorf("[%s] have not addresses", accountID)
-	}
-
-	for _, addr := range addresses {
-
-		addrBalance, err := decoder.wm.GetWalletDetails(addr.Address)
-		if err != nil {
-			continue
-		}
-
-		balance, _ := decimal.NewFromString(addrBalance.Amount)
-
-		if balance.LessThan(minTransfer) || balance.LessThanOrEqual(decimal.Zero) {
-			continue
-		}
-		//计算汇总数量 = 余额 - 保留余额
-		sumAmount := balance.Sub(retainedBalance)
-
-		//减去手续费
-		sumAmount = sumAmount.Sub(decoder.wm.Config.FixFees)
-		if sumAmount.LessThanOrEqual(decimal.Zero) {
+	rawTxArray := make([]*openwallet.RawTransaction, 0)
+	for _, rawTxWithErr := range rawTxWithErrArray {
+		if rawTxWithErr.Error != nil {
+			decoder.wm.Log.Errorf("create summary transaction of %v failed, err: %v", rawTxWithErr.RawTx.TxFrom, rawTxWithErr.Error)
 			continue
 		}
-
-		decoder.wm.Log.Debugf("balance: %v", addrBalance.Amount)
-		decoder.wm.Log.Debugf("fees: %v", decoder.wm.Config.FixFees.String())
-		decoder.wm.Log.Debugf("sumAmount: %v", sumAmount.String())
-
-		//创建一笔交易单
-		rawTx := &openwallet.RawTransaction{
-			Coin:    sumRawTx.Coin,
-			Account: sumRawTx.Account,
-			To: map[string]string{
-				sumRawTx.SummaryAddress: sumAmount.String(),
-			},
-			Required: 1,
-		}
-
-		createErr := decoder.createRawTransaction(
-			wrapper,
-			rawTx,
-			addrBalance)
-		if createErr != nil {
-			return nil, createErr
-		}
-
-		//创建成功，添加到队列
-		rawTxArray = append(rawTxArray, rawTx)
-
+		rawTxArray = append(rawTxArray, rawTxWithErr.RawTx)
 	}
 
 	return rawTxArray, nil
-
 }
 
-//createRawTransaction
+//createRawTransaction 创建交易单，每个接收者对应一笔交易，nonce依次递增
 func (decoder *TransactionDecoder) createRawTransaction(
 	wrapper openwallet.WalletDAI,
 	rawTx *openwallet.RawTransaction,
@@ -341,70 +438,97 @@ func (decoder *TransactionDecoder) createRawTransaction(
 
 	var (
 		accountTotalSent = decimal.Zero
+		totalAmount      = decimal.Zero
 		txFrom           = make([]string, 0)
 		txTo             = make([]string, 0)
 		keySignList      = make([]*openwallet.KeySignature, 0)
-		amountStr        string
-		destination      string
+		txs              = make([]*RawTransaction, 0)
 	)
 
 	decimals := decoder.wm.Decimal()
 
-	for k, v := range rawTx.To {
-		destination = k
-		amountStr = v
-		break
+	//按地址排序，保证交易顺序确定
+	destinations := make([]string, 0, len(rawTx.To))
+	for k := range rawTx.To {
+		destinations = append(destinations, k)
 	}
+	sort.Strings(destinations)
 
-	//计算账户的实际转账amount
-	accountTotalSentAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", destination)
-	if findErr != nil || len(accountTotalSentAddresses) == 0 {
-		amountDec, _ := decimal.NewFromString(amountStr)
-		accountTotalSent = accountTotalSent.Add(amountDec)
+	memo, err := decoder.memo(rawTx)
+	if err != nil {
+		return err
 	}
 
-	txFrom = []string{fmt.Sprintf("%s:%s", addrBalance.Publickey, amountStr)}
-	txTo = []string{fmt.Sprintf("%s:%s", destination, amountStr)}
-
 	addr, err := wrapper.GetAddress(addrBalance.Publickey)
 	if err != nil {
-		return err
+		return openwallet.Errorf(openwallet.ErrAddressNotFound, "address[%s] not found in wallet, err: %v", addrBalance.Publickey, err)
 	}
 
 	nonce := decoder.wm.GetAddressNonce(wrapper, addrBalance)
 
 	decoder.wm.Log.Debugf("nonce: %d", nonce)
-	nonce = nonce + 1
 
-	tx := &RawTransaction{
-		Sender:    addrBalance.Publickey,
-		Recipient: destination,
-		Symbol:    rawTx.Coin.Symbol,
-		Amount:    amountStr,
-		Nonce:     nonce,
+	for _, destination := range destinations {
+
+		if _, err := ValidateAddress(destination); err != nil {
+			return openwallet.Errorf(openwallet.ErrAdressDecodeFailed, "invalid receiver address: %v", err)
+		}
+
+		//签名消息使用规范格式的数量
+		amount, err := ParseTransferAmount(rawTx.To[destination])
+		if err != nil {
+			return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "invalid amount [%s] of receiver [%s]: %v", rawTx.To[destination], destination, err)
+		}
+		amountStr := amount.String()
+		amountDec := amount.Decimal()
+		totalAmount = totalAmount.Add(amountDec)
+
+		//计算账户的实际转账amount
+		accountTotalSentAddresses, findErr := wrapper.GetAddressList(0, -1, "AccountID", rawTx.Account.AccountID, "Address", destination)
+		if findErr != nil || len(accountTotalSentAddresses) == 0 {
+			accountTotalSent = accountTotalSent.Add(amountDec)
+		}
+
+		txTo = append(txTo, fmt.Sprintf("%s:%s", destination, amountStr))
+
+		nonce = nonce + 1
+
+		tx := &RawTransaction{
+			Sender:    addrBalance.Publickey,
+			Recipient: destination,
+			Symbol:    coinSymbol(rawTx.Coin),
+			Amount:    amountStr,
+			Nonce:     nonce,
+			Notes:     memo,
+		}
+		txs = append(txs, tx)
+
+		signature := openwallet.KeySignature{
+			EccType: decoder.wm.CurrentConfig().CurveType,
+			Nonce:   strconv.FormatUint(nonce, 10),
+			Address: addr,
+			Message: hex.EncodeToString(tx.Hash(decoder.wm.CurrentConfig().SignNotes)),
+		}
+		keySignList = append(keySignList, &signature)
 	}
-	txJson, _ := json.Marshal(tx)
-	rawTx.RawHex = hex.EncodeToString(txJson)
 
-	if rawTx.Signatures == nil {
-		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
+	if len(txs) == 1 {
+		txFrom = []string{fmt.Sprintf("%s:%s", addrBalance.Publickey, txs[0].Amount)}
+	} else {
+		txFrom = []string{fmt.Sprintf("%s:%s", addrBalance.Publickey, totalAmount.String())}
 	}
 
-	msg := tx.Hash()
+	rawTx.RawHex = EncodeRawTransactions(txs)
 
-	signature := openwallet.KeySignature{
-		EccType: decoder.wm.Config.CurveType,
-		Address: addr,
-		Message: hex.EncodeToString(msg),
+	if rawTx.Signatures == nil {
+		rawTx.Signatures = make(map[string][]*openwallet.KeySignature)
 	}
-	keySignList = append(keySignList, &signature)
 
 	accountTotalSent = decimal.Zero.Sub(accountTotalSent)
 
-	//rawTx.RawHex = rawHex
 	rawTx.Signatures[rawTx.Account.AccountID] = keySignList
 	rawTx.FeeRate = ""
-	rawTx.Fees = decoder.wm.Config.FixFees.String()
+	rawTx.Fees = decoder.wm.Fees().Mul(decimal.New(int64(len(txs)), 0)).String()
 	rawTx.IsBuilt = true
 	rawTx.TxAmount = accountTotalSent.StringFixed(decimals)
 	rawTx.TxFrom = txFrom
@@ -413,18 +537,189 @@ func (decoder *TransactionDecoder) createRawTransaction(
 	return nil
 }
 
+//memo 交易单的备注，通过扩展参数memo传入
+func (decoder *TransactionDecoder) memo(rawTx *openwallet.RawTransaction) (string, error) {
+	if len(rawTx.ExtParam) == 0 {
+		return "", nil
+	}
+	memo := rawTx.GetExtParam().Get("memo").String()
+	if err := ValidateMemo(memo, decoder.wm.CurrentConfig().MemoMaxLength); err != nil {
+		return "", openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
+	}
+	return memo, nil
+}
+
+//ValidateMemo 校验备注，只允许可打印的ASCII字符，maxLength为最大字节数
+func ValidateMemo(memo string, maxLength int) error {
+	if maxLength > 0 && len(memo) > maxLength {
+		return fmt.Errorf("memo length %d exceeds the limit %d", len(memo), maxLength)
+	}
+	for i := 0; i < len(memo); i++ {
+		if memo[i] < 0x20 || memo[i] > 0x7e {
+			return fmt.Errorf("memo contains invalid character at %d", i)
+		}
+	}
+	return nil
+}
+
+//coinSymbol 交易单资产在链上的symbol
+func coinSymbol(coin openwallet.Coin) string {
+	if coin.IsContract {
+		return ContractSymbol(coin.Contract)
+	}
+	return coin.Symbol
+}
+
 //CreateSummaryRawTransactionWithError 创建汇总交易，返回能原始交易单数组（包含带错误的原始交易单）
+//每个地址的错误记录在对应的RawTransactionWithError中，只有API不可用时整批失败
+//设置了FeesSupportAccount时，手续费不足的地址先由支持账户补充，补充交易放在数组最后，扩展参数feesSupport为补充的地址
 func (decoder *TransactionDecoder) CreateSummaryRawTransactionWithError(wrapper openwallet.WalletDAI, sumRawTx *openwallet.SummaryRawTransaction) ([]*openwallet.RawTransactionWithError, error) {
-	raTxWithErr := make([]*openwallet.RawTransactionWithError, 0)
-	rawTxs, err := decoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+
+	var (
+		rawTxWithErrArray  = make([]*openwallet.RawTransactionWithError, 0)
+		accountID          = sumRawTx.Account.AccountID
+		minTransfer, _     = decimal.NewFromString(sumRawTx.MinTransfer)
+		retainedBalance, _ = decimal.NewFromString(sumRawTx.RetainedBalance)
+	)
+
+	if minTransfer.Cmp(retainedBalance) < 0 {
+		return nil, fmt.Errorf("mini transfer amount must be greater than address retained balance")
+	}
+
+	//获取wallet
+	addresses, err := wrapper.GetAddressList(sumRawTx.AddressStartIndex, sumRawTx.AddressLimit,
+		"AccountID", sumRawTx.Account.AccountID)
 	if err != nil {
 		return nil, err
 	}
-	for _, tx := range rawTxs {
-		raTxWithErr = append(raTxWithErr, &openwallet.RawTransactionWithError{
-			RawTx: tx,
+
+	if len(addresses) == 0 {
+		return nil, fmt.Errorf("[%s] have not addresses", accountID)
+	}
+
+	symbol := ""
+	if sumRawTx.Coin.IsContract {
+		symbol = coinSymbol(sumRawTx.Coin)
+	}
+	list := make([]string, 0, len(addresses))
+	for _, addr := range addresses {
+		list = append(list, addr.Address)
+	}
+
+	fixFees := decoder.wm.Fees()
+
+	//需要补充手续费的地址
+	var (
+		supportTo     = make(map[string]string)
+		supportAmount decimal.Decimal
+	)
+	if sumRawTx.FeesSupportAccount != nil {
+		supportAmount = decoder.feesSupportAmount(sumRawTx.FeesSupportAccount)
+	}
+
+	for i, addrBalance := range decoder.wm.getAddressBalances(list, symbol) {
+
+		address := list[i]
+
+		//创建一笔交易单
+		rawTx := &openwallet.RawTransaction{
+			Coin:     sumRawTx.Coin,
+			Account:  sumRawTx.Account,
+			Required: 1,
+			TxFrom:   []string{address},
+		}
+
+		if addrBalance.err != nil {
+			//API不可用，其他地址也无法汇总
+			if IsBackendUnavailable(addrBalance.err) {
+				return nil, openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "%v", addrBalance.err)
+			}
+			rawTxWithErrArray = append(rawTxWithErrArray, &openwallet.RawTransactionWithError{
+				RawTx: rawTx,
+				Error: openwallet.Errorf(openwallet.ErrCallFullNodeAPIFailed, "get address[%s] balance failed, err: %v", address, addrBalance.err),
+			})
+			continue
+		}
+
+		balance := addrBalance.balance
+		needFees := false
+
+		if sumRawTx.Coin.IsContract {
+			//资产汇总，手续费由主币支付
+			needFees = balance.LessThan(fixFees)
+			balance = addrBalance.tokenBalance
+		}
+
+		if balance.LessThan(minTransfer) || balance.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+		//计算汇总数量 = 余额 - 保留余额
+		sumAmount := TruncateAmount(balance.Sub(retainedBalance)).Decimal()
+		if sumAmount.LessThanOrEqual(decimal.Zero) {
+			continue
+		}
+
+		//减去手续费
+		if !sumRawTx.Coin.IsContract {
+			sumAmount = sumAmount.Sub(fixFees)
+			needFees = sumAmount.LessThanOrEqual(decimal.Zero)
+		}
+
+		if needFees {
+			//由手续费支持账户补充，补充交易确认后的下一次汇总再创建汇总交易
+			if sumRawTx.FeesSupportAccount == nil {
+				decoder.wm.Log.Debugf("address[%s] balance is not enough to pay fees", address)
+			} else if decoder.feesSupportPending(wrapper, address) {
+				decoder.wm.Log.Debugf("address[%s] is waiting for fees support confirmed", address)
+			} else {
+				supportTo[address] = TruncateAmount(supportAmount).String()
+			}
+			continue
+		}
+
+		//手续费足够，清除补充记录
+		if sumRawTx.FeesSupportAccount != nil {
+			decoder.setFeesSupportPending(wrapper, address, 0)
+		}
+
+		decoder.wm.Log.Debugf("balance: %v", balance.String())
+		decoder.wm.Log.Debugf("fees: %v", fixFees.String())
+		decoder.wm.Log.Debugf("sumAmount: %v", sumAmount.String())
+
+		rawTx.To = map[string]string{
+			sumRawTx.SummaryAddress: sumAmount.String(),
+		}
+
+		createErr := decoder.createRawTransaction(
+			wrapper,
+			rawTx,
+			addrBalance.account)
+		if createErr != nil {
+			rawTxWithErrArray = append(rawTxWithErrArray, &openwallet.RawTransactionWithError{
+				RawTx: rawTx,
+				Error: summaryError(createErr),
+			})
+			continue
+		}
+
+		//创建成功，添加到队列
+		rawTxWithErrArray = append(rawTxWithErrArray, &openwallet.RawTransactionWithError{
+			RawTx: rawTx,
 			Error: nil,
 		})
 	}
-	return raTxWithErr, nil
+
+	if len(supportTo) > 0 {
+		rawTxWithErrArray = append(rawTxWithErrArray, decoder.createFeesSupportRawTransaction(wrapper, sumRawTx, supportTo))
+	}
+
+	return rawTxWithErrArray, nil
+}
+
+//summaryError 汇总错误转换为openwallet.Error，保留原有错误码
+func summaryError(err error) *openwallet.Error {
+	if owErr, ok := err.(*openwallet.Error); ok {
+		return owErr
+	}
+	return openwallet.Errorf(openwallet.ErrCreateRawTransactionFailed, "%v", err)
 }
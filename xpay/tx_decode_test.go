@@ -0,0 +1,396 @@
+package xpay
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+func TestTransactionDecoder_MultipleRecipients(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	priv, address := newTestKey(t)
+	server.setAccount(address, Symbol, "1", 7)
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", address)
+	decoder := wm.GetTransactionDecoder()
+	coin := openwallet.Coin{Symbol: Symbol}
+	destA, destB := testAddress("destA"), testAddress("destB")
+	if destA > destB {
+		destA, destB = destB, destA
+	}
+
+	//数量加上每笔交易的手续费超过余额
+	rawTx := &openwallet.RawTransaction{
+		Coin:    coin,
+		Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:      map[string]string{destB: "0.5", destA: "0.49"},
+	}
+	err := decoder.CreateRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Fatalf("err = %v, want insufficient balance", err)
+	}
+
+	rawTx.To = map[string]string{destB: "0.5", destA: "0.4"}
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	txs, err := DecodeRawTransactions(rawTx.RawHex)
+	if err != nil {
+		t.Fatalf("DecodeRawTransactions failed, err: %v", err)
+	}
+	if len(txs) != 2 || len(rawTx.Signatures["acc1"]) != 2 {
+		t.Fatalf("txs = %d, want 2", len(txs))
+	}
+	if txs[0].Recipient != destA || txs[0].Nonce != 8 || txs[1].Recipient != destB || txs[1].Nonce != 9 {
+		t.Errorf("unexpected batch order: %+v, %+v", txs[0], txs[1])
+	}
+	if rawTx.Fees != "0.02" || rawTx.TxAmount != "-0.90000000" {
+		t.Errorf("fees = %s, txAmount = %s", rawTx.Fees, rawTx.TxAmount)
+	}
+
+	signRawTransaction(t, rawTx, map[string][]byte{address: priv})
+	if err := decoder.VerifyRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("VerifyRawTransaction failed, err: %v", err)
+	}
+
+	tx, err := decoder.SubmitRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+	}
+	if len(server.sent) != 2 || server.sent[0]["nonce"] != "8" || server.sent[1]["nonce"] != "9" {
+		t.Fatalf("unexpected sent transactions: %v", server.sent)
+	}
+	if server.sent[0]["signature"] == "" || server.sent[1]["signature"] == "" {
+		t.Errorf("submitted transaction is not signed")
+	}
+	if tx.TxID != "tx1" || len(tx.GetExtParam().Get("txids").Array()) != 2 {
+		t.Errorf("txid = %s, ext = %s", tx.TxID, tx.ExtParam)
+	}
+	if nonce, _ := wrapper.GetAddressExtParam(address, Symbol+"-nonce"); nonce != uint64(9) {
+		t.Errorf("nonce = %v, want 9", nonce)
+	}
+}
+
+func TestTransactionDecoder_PartialSubmit(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	priv, address := newTestKey(t)
+	server.setAccount(address, Symbol, "1", 7)
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", address)
+	decoder := wm.GetTransactionDecoder()
+	rawTx := &openwallet.RawTransaction{
+		Coin:    openwallet.Coin{Symbol: Symbol},
+		Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+		To:      map[string]string{testAddress("destA"): "0.1", testAddress("destB"): "0.2"},
+	}
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	signRawTransaction(t, rawTx, map[string][]byte{address: priv})
+
+	//第二笔交易广播失败，返回已广播的第一笔交易
+	server.setSendFail(9)
+	tx, err := decoder.SubmitRawTransaction(wrapper, rawTx)
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != ErrSubmitRawTransactionPartial {
+		t.Fatalf("err = %v, want partial submit", err)
+	}
+	if tx == nil || tx.TxID != "tx1" || tx.GetExtParam().Get("txids").String() != `["tx1"]` || tx.GetExtParam().Get("pending").Int() != 1 {
+		t.Fatalf("unexpected partial transaction: %+v", tx)
+	}
+	if rawTx.TxID != "tx1" || rawTx.IsSubmit {
+		t.Errorf("rawTx txid = %s, isSubmit = %v", rawTx.TxID, rawTx.IsSubmit)
+	}
+	if nonce, _ := wrapper.GetAddressExtParam(address, Symbol+"-nonce"); nonce != uint64(8) {
+		t.Errorf("nonce = %v, want 8", nonce)
+	}
+
+	//再次提交只广播未成功的交易
+	tx, err = decoder.SubmitRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+	}
+	if len(server.sent) != 2 || server.sent[1]["nonce"] != "9" {
+		t.Fatalf("unexpected sent transactions: %v", server.sent)
+	}
+	if tx.TxID != "tx1" || len(tx.GetExtParam().Get("txids").Array()) != 2 || !rawTx.IsSubmit {
+		t.Errorf("txid = %s, ext = %s, isSubmit = %v", tx.TxID, tx.ExtParam, rawTx.IsSubmit)
+	}
+}
+
+func TestTransactionDecoder_FundingPlan(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	keys := make(map[string][]byte)
+	addresses := make([]string, 0)
+	for i, balance := range []string{"0.3", "0.5", "0.4"} {
+		priv, address := newTestKey(t)
+		keys[address] = priv
+		addresses = append(addresses, address)
+		server.setAccount(address, Symbol, balance, uint64(i))
+	}
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", addresses...)
+	decoder := wm.GetTransactionDecoder()
+	newRawTx := func() *openwallet.RawTransaction {
+		return &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: Symbol},
+			Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+			To:      map[string]string{testAddress("dest"): "1"},
+		}
+	}
+
+	//默认只使用单个地址
+	err := decoder.CreateRawTransaction(wrapper, newRawTx())
+	if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != openwallet.ErrInsufficientBalanceOfAccount {
+		t.Fatalf("err = %v, want insufficient balance", err)
+	}
+
+	//归集到余额最多的地址后出账
+	rawTx := newRawTx()
+	rawTx.SetExtParam("fundingMode", FundingModeConsolidate)
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	plan := rawTx.GetExtParam().Get("fundingPlan")
+	steps := plan.Get("steps").Array()
+	if len(steps) != 3 || plan.Get("totalFees").String() != "0.03" {
+		t.Fatalf("unexpected plan: %s", plan.Raw)
+	}
+	hot := addresses[1]
+	if steps[0].Get("from").String() != addresses[2] || steps[0].Get("to").String() != hot || steps[0].Get("amount").String() != "0.39" ||
+		steps[1].Get("from").String() != addresses[0] || steps[1].Get("amount").String() != "0.12" ||
+		steps[2].Get("type").String() != FundingStepPayout || steps[2].Get("from").String() != hot || steps[2].Get("amount").String() != "1" {
+		t.Errorf("unexpected plan: %s", plan.Raw)
+	}
+	if rawTx.TxAmount != "-1.00000000" {
+		t.Errorf("txAmount = %s, want -1", rawTx.TxAmount)
+	}
+
+	//多个地址分别出账
+	wm.CurrentConfig().FundingMode = FundingModeSplit
+	rawTx = newRawTx()
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	txs, _ := DecodeRawTransactions(rawTx.RawHex)
+	if len(txs) != 3 || txs[0].Amount != "0.49" || txs[1].Amount != "0.39" || txs[2].Amount != "0.12" {
+		t.Fatalf("unexpected split transactions: %+v", txs)
+	}
+	if txs[0].Sender != addresses[1] || txs[0].Nonce != 2 || txs[2].Sender != addresses[0] || txs[2].Nonce != 1 {
+		t.Errorf("unexpected sender or nonce: %+v", txs)
+	}
+
+	signRawTransaction(t, rawTx, keys)
+	if err := decoder.VerifyRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("VerifyRawTransaction failed, err: %v", err)
+	}
+	if _, err := decoder.SubmitRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+	}
+	if len(server.sent) != 3 {
+		t.Errorf("sent = %d, want 3", len(server.sent))
+	}
+
+	//归集交易确认后才广播出账交易
+	rawTx = newRawTx()
+	rawTx.SetExtParam("fundingMode", FundingModeConsolidate)
+	if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+		t.Fatalf("CreateRawTransaction failed, err: %v", err)
+	}
+	signRawTransaction(t, rawTx, keys)
+	for i := 0; i < 2; i++ {
+		tx, err := decoder.SubmitRawTransaction(wrapper, rawTx)
+		if owErr, ok := err.(*openwallet.Error); !ok || owErr.Code() != ErrSubmitRawTransactionPending {
+			t.Fatalf("err = %v, want payout pending", err)
+		}
+		if len(server.sent) != 5 || tx.GetExtParam().Get("txids").String() != `["tx4","tx5"]` || rawTx.IsSubmit {
+			t.Fatalf("unexpected consolidation stage: sent %d, ext %s", len(server.sent), tx.ExtParam)
+		}
+	}
+	server.addBlock("h100")
+	server.addTransaction("tx4", 100, addresses[2], hot, Symbol, "0.39", "")
+	server.addTransaction("tx5", 100, addresses[0], hot, Symbol, "0.12", "")
+	tx, err := decoder.SubmitRawTransaction(wrapper, rawTx)
+	if err != nil {
+		t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+	}
+	if len(server.sent) != 6 || server.sent[5]["sender"] != hot || len(tx.GetExtParam().Get("txids").Array()) != 3 || !rawTx.IsSubmit {
+		t.Errorf("payout is not submitted: sent %d, ext %s", len(server.sent), tx.ExtParam)
+	}
+}
+
+func TestTransactionDecoder_Memo(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	priv, address := newTestKey(t)
+	server.setAccount(address, Symbol, "1", 0)
+
+	wm := newMockWalletManager(t, server, "MemoMaxLength = 16\n")
+	wrapper := newMockWalletDAI("acc1", address)
+	decoder := wm.GetTransactionDecoder()
+	newRawTx := func(memo string) *openwallet.RawTransaction {
+		rawTx := &openwallet.RawTransaction{
+			Coin:    openwallet.Coin{Symbol: Symbol},
+			Account: &openwallet.AssetsAccount{AccountID: "acc1"},
+			To:      map[string]string{testAddress("dest"): "0.1"},
+		}
+		rawTx.SetExtParam("memo", memo)
+		return rawTx
+	}
+
+	for _, memo := range []string{"memo longer than limit", "bad\nmemo", "中文"} {
+		if err := decoder.CreateRawTransaction(wrapper, newRawTx(memo)); err == nil {
+			t.Errorf("memo %q should be rejected", memo)
+		}
+	}
+
+	//默认备注不参与签名，开启SignNotes后追加在nonce之后签名
+	for i, signNotes := range []bool{false, true} {
+		if signNotes {
+			wm = newMockWalletManager(t, server, "MemoMaxLength = 16\nSignNotes = true\n")
+			decoder = wm.GetTransactionDecoder()
+		}
+		rawTx := newRawTx("ref-12345")
+		if err := decoder.CreateRawTransaction(wrapper, rawTx); err != nil {
+			t.Fatalf("CreateRawTransaction failed, err: %v", err)
+		}
+		txs, _ := DecodeRawTransactions(rawTx.RawHex)
+		noMemo := *txs[0]
+		noMemo.Notes = ""
+		message := rawTx.Signatures["acc1"][0].Message
+		if txs[0].Notes != "ref-12345" || (message == hex.EncodeToString(noMemo.Hash(true))) != !signNotes {
+			t.Fatalf("SignNotes = %v: memo is not carried or signed as configured: %+v", signNotes, txs[0])
+		}
+
+		signRawTransaction(t, rawTx, map[string][]byte{address: priv})
+		if err := decoder.VerifyRawTransaction(wrapper, rawTx); err != nil {
+			t.Fatalf("VerifyRawTransaction failed, err: %v", err)
+		}
+		if _, err := decoder.SubmitRawTransaction(wrapper, rawTx); err != nil {
+			t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+		}
+		if server.sent[i]["notes"] != "ref-12345" {
+			t.Errorf("sent notes = %q, want ref-12345", server.sent[i]["notes"])
+		}
+	}
+}
+
+func TestTransactionDecoder_SummaryWithError(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	summary := testAddress("summary")
+	server.setAccount("addr1", Symbol, "5", 0)
+	server.setAccount("addr3", Symbol, "3", 0)
+	server.setAccount("addr4", Symbol, "0.001", 0)
+	//链上账户的公钥不在钱包中
+	server.accounts["addr3/"+Symbol]["publickey"] = "unknown"
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", "addr1", "addr2", "addr3", "addr4")
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin:            openwallet.Coin{Symbol: Symbol},
+		Account:         &openwallet.AssetsAccount{AccountID: "acc1"},
+		SummaryAddress:  summary,
+		MinTransfer:     "1",
+		RetainedBalance: "0",
+		AddressLimit:    -1,
+	}
+
+	decoder := wm.GetTransactionDecoder().(*TransactionDecoder)
+	rawTxWithErrs, err := decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if err != nil {
+		t.Fatalf("CreateSummaryRawTransactionWithError failed, err: %v", err)
+	}
+	if len(rawTxWithErrs) != 3 {
+		t.Fatalf("results = %d, want 3", len(rawTxWithErrs))
+	}
+	if rawTxWithErrs[0].Error != nil || rawTxWithErrs[0].RawTx.To[summary] != "4.99" {
+		t.Errorf("addr1 result: %v, %v", rawTxWithErrs[0].RawTx.To, rawTxWithErrs[0].Error)
+	}
+	if rawTxWithErrs[1].Error == nil || rawTxWithErrs[1].Error.Code() != openwallet.ErrCallFullNodeAPIFailed || rawTxWithErrs[1].RawTx.TxFrom[0] != "addr2" {
+		t.Errorf("addr2 error = %v, want balance fetch failure", rawTxWithErrs[1].Error)
+	}
+	if rawTxWithErrs[2].Error == nil || rawTxWithErrs[2].Error.Code() != openwallet.ErrAddressNotFound {
+		t.Errorf("addr3 error = %v, want address not found", rawTxWithErrs[2].Error)
+	}
+
+	rawTxs, err := decoder.CreateSummaryRawTransaction(wrapper, sumRawTx)
+	if err != nil || len(rawTxs) != 1 {
+		t.Errorf("CreateSummaryRawTransaction = %d, %v, want 1 transaction", len(rawTxs), err)
+	}
+}
+
+func TestTransactionDecoder_SummaryFeesSupport(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	addr1, summary := testAddress("addr1"), testAddress("summary")
+	feePriv, fee1 := newTestKey(t)
+	server.setAccount(addr1, Symbol, "0.005", 0)
+	server.setAccount("addr2", Symbol, "2", 0)
+	server.setAccount(fee1, Symbol, "1", 0)
+
+	wm := newMockWalletManager(t, server, "")
+	wrapper := newMockWalletDAI("acc1", addr1, "addr2")
+	wrapper.addresses = append(wrapper.addresses, newMockWalletDAI("feeAcc", fee1).addresses...)
+	sumRawTx := &openwallet.SummaryRawTransaction{
+		Coin:            openwallet.Coin{Symbol: Symbol},
+		Account:         &openwallet.AssetsAccount{AccountID: "acc1"},
+		SummaryAddress:  summary,
+		MinTransfer:     "0.001",
+		RetainedBalance: "0",
+		AddressLimit:    -1,
+		FeesSupportAccount: &openwallet.FeesSupportAccount{
+			AccountID:        "feeAcc",
+			FeesSupportScale: "2",
+		},
+	}
+	decoder := wm.GetTransactionDecoder().(*TransactionDecoder)
+
+	rawTxWithErrs, err := decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if err != nil {
+		t.Fatalf("CreateSummaryRawTransactionWithError failed, err: %v", err)
+	}
+	if len(rawTxWithErrs) != 2 {
+		t.Fatalf("results = %d, want sweep of addr2 and fees support", len(rawTxWithErrs))
+	}
+	support := rawTxWithErrs[1]
+	if support.Error != nil || support.RawTx.To[addr1] != "0.02" || support.RawTx.TxFrom[0] != fee1+":0.02" {
+		t.Fatalf("unexpected fees support: %+v, %v", support.RawTx.To, support.Error)
+	}
+	if support.RawTx.GetExtParam().Get("feesSupport.0").String() != addr1 {
+		t.Errorf("fees support ext param: %s", support.RawTx.ExtParam)
+	}
+
+	//补充交易没有广播时不记录，下次汇总重新补充
+	rawTxWithErrs, _ = decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if len(rawTxWithErrs) != 2 || decoder.feesSupportPending(wrapper, addr1) {
+		t.Fatalf("results = %d, unsubmitted fees support should not block addr1", len(rawTxWithErrs))
+	}
+
+	//补充广播后，确认前不重复补充
+	support = rawTxWithErrs[1]
+	signRawTransaction(t, support.RawTx, map[string][]byte{fee1: feePriv})
+	if _, err := decoder.SubmitRawTransaction(wrapper, support.RawTx); err != nil {
+		t.Fatalf("SubmitRawTransaction failed, err: %v", err)
+	}
+	rawTxWithErrs, _ = decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if len(rawTxWithErrs) != 1 {
+		t.Fatalf("results = %d, want only sweep of addr2", len(rawTxWithErrs))
+	}
+
+	//补充到账后汇总
+	server.setAccount(addr1, Symbol, "0.025", 0)
+	rawTxWithErrs, _ = decoder.CreateSummaryRawTransactionWithError(wrapper, sumRawTx)
+	if len(rawTxWithErrs) != 2 || rawTxWithErrs[0].RawTx.To[summary] != "0.015" {
+		t.Fatalf("addr1 is not swept after fees support confirmed")
+	}
+	if decoder.feesSupportPending(wrapper, addr1) {
+		t.Errorf("fees support record is not cleared")
+	}
+}
@@ -0,0 +1,227 @@
+/*
+ * Copyright 2018 The OpenWallet Authors
+ * This file is part of the OpenWallet library.
+ *
+ * The OpenWallet library is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU Lesser General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * The OpenWallet library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE. See the
+ * GNU Lesser General Public License for more details.
+ */
+
+package xpay
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	TxVerifyOff    = "off"    //不校验
+	TxVerifyFlag   = "flag"   //签名无效的交易仍然提取，状态标记为失败
+	TxVerifyReject = "reject" //签名无效的交易不提取，记录在本地区块中；查询nonce失败时记录为未扫描，之后重新扫描
+)
+
+const defaultTxVerifyNonceWindow = 100
+
+//ParseDERSignature DER编码的签名解析为64字节的r||s
+//r和s按无符号整数解析，兼容FillSig生成的没有符号位补0的签名
+func ParseDERSignature(der []byte) ([]byte, error) {
+	if len(der) < 8 || der[0] != 0x30 || int(der[1]) != len(der)-2 {
+		return nil, fmt.Errorf("invalid DER signature")
+	}
+	signature := make([]byte, 64)
+	rest := der[2:]
+	for i := 0; i < 2; i++ {
+		if len(rest) < 2 || rest[0] != 0x02 || int(rest[1]) > len(rest)-2 || rest[1] == 0 {
+			return nil, fmt.Errorf("invalid DER signature")
+		}
+		n := new(big.Int).SetBytes(rest[2 : 2+rest[1]])
+		if n.Sign() <= 0 || n.BitLen() > 256 {
+			return nil, fmt.Errorf("invalid DER signature: r or s out of range")
+		}
+		b := n.Bytes()
+		copy(signature[(i+1)*32-len(b):(i+1)*32], b)
+		rest = rest[2+rest[1]:]
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("invalid DER signature: trailing data")
+	}
+	return signature, nil
+}
+
+//senderNonceError 无法确定交易的nonce（查询失败或不在查找范围内），不能判断签名是否有效，交易之后可以重新校验
+type senderNonceError struct {
+	err error
+}
+
+func (e *senderNonceError) Error() string {
+	return fmt.Sprintf("sender nonce unknown: %v", e.err)
+}
+
+//senderNonceCache 同一区块内每个发送者的nonce只查询一次，区块高度变化时清空
+type senderNonceCache struct {
+	mu     sync.Mutex
+	height uint64
+	nonces map[string]*senderNonce
+}
+
+type senderNonce struct {
+	once  sync.Once
+	nonce uint64
+	err   error
+}
+
+//get 发送者在height区块扫描时的nonce，并发查询同一发送者时只请求一次，查询失败不缓存
+func (cache *senderNonceCache) get(wm *WalletManager, height uint64, sender string) (uint64, error) {
+	cache.mu.Lock()
+	if cache.nonces == nil || cache.height != height {
+		cache.height = height
+		cache.nonces = make(map[string]*senderNonce)
+	}
+	entry, exist := cache.nonces[sender]
+	if !exist {
+		entry = &senderNonce{}
+		cache.nonces[sender] = entry
+	}
+	cache.mu.Unlock()
+
+	entry.once.Do(func() {
+		account, err := wm.GetWalletDetails(sender)
+		if err != nil {
+			entry.err = err
+			return
+		}
+		entry.nonce = account.Nonce
+	})
+
+	if entry.err != nil {
+		cache.mu.Lock()
+		if cache.nonces[sender] == entry {
+			delete(cache.nonces, sender)
+		}
+		cache.mu.Unlock()
+	}
+	return entry.nonce, entry.err
+}
+
+//VerifyTransactionSignature 重新计算链上交易的签名消息，用发送者公钥校验签名
+//接口未返回nonce时，从发送者当前nonce向前查找TxVerifyNonceWindow个nonce，找不到时交易无法校验，不判定为无效
+func (wm *WalletManager) VerifyTransactionSignature(tx *Transaction) error {
+	return wm.verifyTransactionSignature(tx, func(sender string) (uint64, error) {
+		account, err := wm.GetWalletDetails(sender)
+		if err != nil {
+			return 0, err
+		}
+		return account.Nonce, nil
+	})
+}
+
+//verifyTransactionSignature 校验交易签名，senderNonce查询发送者当前的nonce
+func (wm *WalletManager) verifyTransactionSignature(tx *Transaction, senderNonce func(sender string) (uint64, error)) error {
+
+	if tx.Signature == "" {
+		return fmt.Errorf("signature is empty")
+	}
+	der, err := hex.DecodeString(tx.Signature)
+	if err != nil {
+		return fmt.Errorf("signature is not hex")
+	}
+	signature, err := ParseDERSignature(der)
+	if err != nil {
+		return err
+	}
+
+	sender, err := ValidateAddress(tx.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %v", err)
+	}
+	publicKey, _ := hex.DecodeString(sender)
+	pub := owcrypt.PointDecompress(publicKey, wm.CurveType())
+
+	amount, _ := decimal.NewFromString(tx.Amount)
+	rawTx := &RawTransaction{
+		Sender:    tx.From,
+		Recipient: tx.To,
+		Symbol:    tx.Symbol,
+		Amount:    amount.Abs().String(),
+		Notes:     tx.Memo,
+	}
+
+	verify := func(nonce uint64) bool {
+		rawTx.Nonce = nonce
+		return owcrypt.Verify(pub[1:], nil, rawTx.Hash(wm.CurrentConfig().SignNotes), signature, wm.CurveType()) == owcrypt.SUCCESS
+	}
+
+	if tx.Nonce > 0 {
+		if verify(tx.Nonce) {
+			return nil
+		}
+		return fmt.Errorf("signature does not match sender %s with nonce %d", tx.From, tx.Nonce)
+	}
+
+	current, err := senderNonce(tx.From)
+	if err != nil {
+		return &senderNonceError{err: fmt.Errorf("get sender nonce failed: %v", err)}
+	}
+	window := wm.CurrentConfig().TxVerifyNonceWindow
+	if window == 0 {
+		window = defaultTxVerifyNonceWindow
+	}
+	for i, nonce := uint64(0), current; i < window && nonce > 0; i, nonce = i+1, nonce-1 {
+		if verify(nonce) {
+			tx.Nonce = nonce
+			return nil
+		}
+	}
+	//发送者之后的交易超过查找范围时（追赶旧区块），找不到nonce不代表签名无效
+	return &senderNonceError{err: fmt.Errorf("signature does not match sender %s within %d nonces below %d", tx.From, window, current)}
+}
+
+//verifyTransaction 按配置校验交易签名，返回false表示交易不提取
+func (bs *BlockScanner) verifyTransaction(tx *Transaction, result *ExtractResult) bool {
+
+	mode := bs.wm.CurrentConfig().TxVerifyMode
+	if mode == "" || mode == TxVerifyOff {
+		return true
+	}
+
+	//重扫区块时，已经拒绝的交易不再校验
+	if mode == TxVerifyReject && bs.blockStore.rejected(tx.BlockHeight, tx.BlockHash, tx.Hash) {
+		result.rejected = true
+		return false
+	}
+
+	err := bs.wm.verifyTransactionSignature(tx, func(sender string) (uint64, error) {
+		return bs.senderNonces.get(bs.wm, tx.BlockHeight, sender)
+	})
+	if err == nil {
+		return true
+	}
+
+	//nonce无法确定时不能判断签名是否有效，区块记录为未扫描，之后重新扫描
+	if _, temporary := err.(*senderNonceError); temporary {
+		bs.wm.Log.Std.Error("transaction %s is not verified: %v", tx.Hash, err)
+		result.Success = false
+		return false
+	}
+
+	if mode == TxVerifyReject {
+		bs.wm.Log.Std.Error("transaction %s rejected, signature verification failed: %v", tx.Hash, err)
+		result.rejected = true
+		return false
+	}
+
+	bs.wm.Log.Std.Warning("transaction %s flagged, signature verification failed: %v", tx.Hash, err)
+	tx.verifyErr = err
+	return true
+}
@@ -0,0 +1,174 @@
+package xpay
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/blocktree/go-owcrypt"
+	"github.com/blocktree/openwallet/v2/openwallet"
+)
+
+//addSignedTransaction 签名交易并加入区块，链上数量为chainAmount，withNonce为false时接口不返回nonce
+func addSignedTransaction(t *testing.T, server *mockServer, priv []byte, txid string, height uint64, sender, receiver, amount, chainAmount string, nonce uint64, withNonce bool) {
+	rawTx := &RawTransaction{Sender: sender, Recipient: receiver, Symbol: Symbol, Amount: amount, Nonce: nonce, Notes: "memo"}
+	sig, _, ret := owcrypt.Signature(priv, nil, rawTx.Hash(false), CurveType)
+	if ret != owcrypt.SUCCESS {
+		t.Fatalf("sign failed")
+	}
+	rawTx.FillSig(sig)
+	tx := server.addTransaction(txid, height, sender, receiver, Symbol, chainAmount, "memo")
+	server.mu.Lock()
+	tx["signature"] = rawTx.Signature
+	if withNonce {
+		tx["nonce"] = nonce
+	}
+	server.mu.Unlock()
+}
+
+func TestBlockScanner_VerifyTransactionSignature(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	priv, sender := newTestKey(t)
+	receiver := testAddress("receiver")
+	server.setAccount(sender, Symbol, "10", 7)
+
+	height := server.addBlock("h100")
+	addSigned := func(txid, amount, chainAmount string, nonce uint64, withNonce bool) {
+		addSignedTransaction(t, server, priv, txid, height, sender, receiver, amount, chainAmount, nonce, withNonce)
+	}
+	addSigned("tx1", "1.5", "1.50", 5, false)
+	addSigned("tx2", "1", "100", 6, true)
+	addSigned("tx3", "2", "2", 3, true)
+	addSigned("tx4", "1", "100", 4, false)
+
+	scanTarget := func(target openwallet.ScanTarget) (string, bool) {
+		return "acc1", target.Address == receiver
+	}
+
+	wm := newMockWalletManager(t, server, "TxVerifyMode = flag\n")
+	bs := wm.Blockscanner.(*BlockScanner)
+	for txid, wantStatus := range map[string]string{"tx1": "1", "tx2": "0", "tx3": "1"} {
+		result := bs.ExtractTransaction(txid, scanTarget)
+		if !result.Success || result.extractData["acc1"] == nil {
+			t.Fatalf("extract %s failed", txid)
+		}
+		if status := result.extractData["acc1"].Transaction.Status; status != wantStatus {
+			t.Errorf("%s status = %s, want %s", txid, status, wantStatus)
+		}
+	}
+
+	wm = newMockWalletManager(t, server, "TxVerifyMode = reject\n")
+	bs = wm.Blockscanner.(*BlockScanner)
+	if result := bs.ExtractTransaction("tx2", scanTarget); !result.Success || !result.rejected || len(result.extractData) != 0 {
+		t.Errorf("tampered transaction is not rejected")
+	}
+	if result := bs.ExtractTransaction("tx1", scanTarget); !result.Success || len(result.extractData) != 1 {
+		t.Errorf("valid transaction is rejected")
+	}
+	//没有nonce时找不到匹配的nonce，无法确定签名无效，之后重新扫描
+	if result := bs.ExtractTransaction("tx4", scanTarget); result.Success || result.rejected {
+		t.Errorf("transaction without matching nonce should be rescanned, not rejected")
+	}
+
+	//节点不签名备注时，开启SignNotes校验失败
+	wm = newMockWalletManager(t, server, "TxVerifyMode = flag\nSignNotes = true\n")
+	bs = wm.Blockscanner.(*BlockScanner)
+	if result := bs.ExtractTransaction("tx3", scanTarget); !result.Success || result.extractData["acc1"].Transaction.Status != "0" {
+		t.Errorf("signature without notes passes with SignNotes")
+	}
+}
+
+func TestBlockScanner_VerifyReject(t *testing.T) {
+	server := newMockServer()
+	defer server.Close()
+	priv1, sender1 := newTestKey(t)
+	priv2, sender2 := newTestKey(t)
+	_, sender3 := newTestKey(t)
+	receiver := testAddress("receiver")
+	server.setAccount(sender1, Symbol, "10", 7)
+	server.setAccount(sender2, Symbol, "10", 3)
+
+	server.addBlock("h100")
+	h101 := server.addBlock("h101", "tx1", "tx2", "tx3")
+	addSignedTransaction(t, server, priv1, "tx1", h101, sender1, receiver, "1", "1", 6, false)
+	addSignedTransaction(t, server, priv1, "tx2", h101, sender1, receiver, "2", "2", 5, false)
+	addSignedTransaction(t, server, priv2, "tx3", h101, sender2, receiver, "3", "300", 3, true)
+
+	dataDir := newTempDir(t)
+	newScanner := func() *BlockScanner {
+		wm := newMockWalletManager(t, server, "TxVerifyMode = reject\nDataDir = "+dataDir+"\n")
+		bs := wm.Blockscanner.(*BlockScanner)
+		bs.SetBlockchainDAI(newMockBlockchainDAI())
+		bs.SetBlockScanTargetFunc(func(target openwallet.ScanTarget) (string, bool) {
+			return "acc1", target.Address == receiver
+		})
+		return bs
+	}
+
+	//同一区块每个发送者只查询一次nonce，无效交易记录在本地区块中，不记录为未扫描
+	bs := newScanner()
+	observer := newMockObserver()
+	bs.AddObserver(observer)
+	if err := bs.ScanBlock(h101); err != nil {
+		t.Fatalf("ScanBlock failed, err: %v", err)
+	}
+	if server.queries != 1 {
+		t.Errorf("sender nonce queries = %d, want 1", server.queries)
+	}
+	if local, _ := bs.GetLocalBlockContents(h101); local == nil || len(local.Rejected) != 1 || local.Rejected[0] != "tx3" {
+		t.Fatalf("rejected transactions are not recorded: %+v", local)
+	}
+	if records, _ := bs.BlockchainDAI.GetUnscanRecords(Symbol); len(records) != 0 {
+		t.Errorf("rejected transaction is recorded as unscanned: %d records", len(records))
+	}
+	if data := observer.data["acc1"]; len(data) != 2 {
+		t.Errorf("extracted %d transactions, want 2", len(data))
+	}
+
+	//重启后重扫区块，已拒绝的交易不再校验
+	bs = newScanner()
+	if err := bs.ScanBlock(h101); err != nil {
+		t.Fatalf("ScanBlock failed, err: %v", err)
+	}
+	if server.queries != 2 {
+		t.Errorf("sender nonce queries after rescan = %d, want 2", server.queries)
+	}
+
+	//查询nonce失败时交易没有校验，区块之后重新扫描
+	h102 := server.addBlock("h102", "tx4")
+	addSignedTransaction(t, server, priv1, "tx4", h102, sender3, receiver, "1", "1", 1, false)
+	result := bs.ExtractTransaction("tx4", bs.ScanTargetFunc)
+	if result.Success || result.rejected {
+		t.Errorf("transaction with unknown sender nonce should be rescanned")
+	}
+}
+
+func TestParseDERSignature(t *testing.T) {
+	priv, _ := newTestKey(t)
+	sig, _, _ := owcrypt.Signature(priv, nil, owcrypt.Hash([]byte("msg"), 0, owcrypt.HASH_ALG_SHA256), CurveType)
+	rawTx := &RawTransaction{}
+	rawTx.FillSig(sig)
+	der, _ := hex.DecodeString(rawTx.Signature)
+	parsed, err := ParseDERSignature(der)
+	if err != nil {
+		t.Fatalf("ParseDERSignature failed, err: %v", err)
+	}
+	if string(parsed) != string(sig) {
+		t.Errorf("parsed signature does not match")
+	}
+	if _, err := ParseDERSignature(der[:len(der)-1]); err == nil {
+		t.Errorf("truncated signature should fail")
+	}
+}
+
+func TestParseDERSignature_StrictEncoding(t *testing.T) {
+	//符号位补0的标准DER编码
+	r := append([]byte{0x00, 0x80}, make([]byte, 31)...)
+	s := append([]byte{0x01}, make([]byte, 31)...)
+	der := append([]byte{0x30, byte(4 + len(r) + len(s)), 0x02, byte(len(r))}, r...)
+	der = append(append(der, 0x02, byte(len(s))), s...)
+	parsed, err := ParseDERSignature(der)
+	if err != nil || parsed[0] != 0x80 || parsed[32] != 0x01 {
+		t.Errorf("ParseDERSignature = %x, %v", parsed, err)
+	}
+}
@@ -15,10 +15,12 @@
 package xpay
 
 import (
+	"fmt"
 	"github.com/astaxie/beego/config"
 	"github.com/blocktree/openwallet/v2/log"
 	"github.com/blocktree/openwallet/v2/openwallet"
-	"github.com/shopspring/decimal"
+	"strings"
+	"time"
 )
 
 //FullName 币种全名
@@ -28,12 +30,12 @@ func (wm *WalletManager) FullName() string {
 
 //CurveType 曲线类型
 func (wm *WalletManager) CurveType() uint32 {
-	return wm.Config.CurveType
+	return wm.CurrentConfig().CurveType
 }
 
 //Symbol 币种标识
 func (wm *WalletManager) Symbol() string {
-	return wm.Config.Symbol
+	return wm.CurrentConfig().Symbol
 }
 
 //小数位精度
@@ -56,17 +58,257 @@ func (wm *WalletManager) GetBlockScanner() openwallet.BlockScanner {
 	return wm.Blockscanner
 }
 
+//GetSmartContractDecoder 平台资产解析器
+func (wm *WalletManager) GetSmartContractDecoder() openwallet.SmartContractDecoder {
+	return wm.ContractDecoder
+}
+
 //LoadAssetsConfig 加载外部配置
 func (wm *WalletManager) LoadAssetsConfig(c config.Configer) error {
-	wm.Config.ServerAPI = c.String("ServerAPI")
-	wm.client = NewClient(wm.Config.ServerAPI, false)
-	wm.Config.FixFees, _ = decimal.NewFromString(c.String("FixFees"))
+	wm.reloadMu.Lock()
+	defer wm.reloadMu.Unlock()
+
+	cfg, client, err := wm.newAssetsConfig(c)
+	if err != nil {
+		return err
+	}
+
+	//先读取登记队列和本地区块，全部成功后再切换，失败时保持当前配置
+	informPath, records, err := readInformQueue(cfg.DataDir, cfg.localFile("inform_queue.json"))
+	if err != nil {
+		return fmt.Errorf("open inform queue failed: %v", err)
+	}
+	bs, _ := wm.Blockscanner.(*BlockScanner)
+	var (
+		blocksDir string
+		blocks    []*LocalBlock
+	)
+	if bs != nil {
+		if blocksDir, blocks, err = readLocalBlocks(cfg.DataDir, cfg.localFile("blocks")); err != nil {
+			return fmt.Errorf("open local blocks failed: %v", err)
+		}
+	}
+
+	//扫描器的配置在下一次扫描或启动时应用
+	wm.storeState(cfg, client)
+	wm.inform.use(informPath, records)
+	if bs != nil {
+		bs.blockStore.use(blocksDir, blocks)
+	}
 	return nil
 }
 
-//InitAssetsConfig 初始化默认配置
+//newAssetsConfig 解析配置并创建API客户端，不修改当前配置
+func (wm *WalletManager) newAssetsConfig(c config.Configer) (*WalletConfig, *Client, error) {
+	cfg := NewConfig(Symbol)
+	if err := ValidateAssetsConfig(c); err != nil {
+		return nil, nil, err
+	}
+
+	//网络
+	network, err := GetNetworkProfile(c.DefaultString("Network", NetworkMainnet))
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.Network = network.Name
+	cfg.Symbol = network.Symbol
+	cfg.CurveType = network.CurveType
+	if cfg.NetworkCheckHeight, err = configUint(c, "NetworkCheckHeight", network.CheckHeight); err != nil {
+		return nil, nil, err
+	}
+	cfg.NetworkCheckHash = c.DefaultString("NetworkCheckHash", network.CheckHash)
+
+	cfg.ServerAPI = c.DefaultString("ServerAPI", network.ServerAPI)
+	fees, err := ParseAmount(c.DefaultString("FixFees", network.FixFees))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid FixFees: %v", err)
+	}
+	cfg.FixFees = fees.Decimal()
+	cfg.DynamicFees = c.DefaultBool("DynamicFees", false)
+	cfg.FeeAPIPath = c.String("FeeAPIPath")
+	cfg.FeeCacheTTL = configSeconds(c, "FeeCacheTTL", defaultFeeCacheTTL)
+	cfg.FeeObservedTTL = configSeconds(c, "FeeObservedTTL", defaultFeeObservedTTL)
+
+	//接口限流
+	cfg.RateLimit = EndpointLimit{
+		Rate:        c.DefaultFloat("RateLimit", 0),
+		Burst:       c.DefaultInt("RateBurst", 0),
+		MaxInFlight: c.DefaultInt("MaxInFlight", 0),
+	}
+	cfg.RateLimitRetries = c.DefaultInt("RateLimitRetries", defaultRateLimitRetries)
+	cfg.EndpointLimits = make(map[string]EndpointLimit)
+	for _, s := range c.Strings("EndpointRateLimits") {
+		prefix, limit, err := ParseEndpointLimit(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.EndpointLimits[prefix] = limit
+	}
+
+	//接口熔断
+	cfg.CircuitFailureThreshold = c.DefaultInt("CircuitFailureThreshold", defaultCircuitFailureThreshold)
+	cfg.CircuitOpenTimeout = configSeconds(c, "CircuitOpenTimeout", defaultCircuitOpenTimeout)
+
+	//接口认证
+	auth := NewClientAuth()
+	for _, s := range c.Strings("APIHeaders") {
+		name, value, err := ParseHeader(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		auth.Headers[name] = value
+	}
+	auth.BearerToken = c.String("APIBearerToken")
+	auth.SignSecret = c.String("APISignSecret")
+	auth.SignatureHeader = c.DefaultString("APISignatureHeader", defaultSignatureHeader)
+	auth.TimestampHeader = c.DefaultString("APITimestampHeader", defaultTimestampHeader)
+	cfg.Auth = auth
+
+	//HTTP传输
+	def := NewTransportConfig()
+	transport := &TransportConfig{
+		CAFile:                c.String("TLSCAFile"),
+		CertFile:              c.String("TLSCertFile"),
+		KeyFile:               c.String("TLSKeyFile"),
+		InsecureSkipVerify:    c.DefaultBool("TLSInsecureSkipVerify", false),
+		Proxy:                 c.String("Proxy"),
+		MaxIdleConns:          c.DefaultInt("MaxIdleConns", def.MaxIdleConns),
+		MaxIdleConnsPerHost:   c.DefaultInt("MaxIdleConnsPerHost", def.MaxIdleConnsPerHost),
+		MaxConnsPerHost:       c.DefaultInt("MaxConnsPerHost", def.MaxConnsPerHost),
+		IdleConnTimeout:       configSeconds(c, "IdleConnTimeout", def.IdleConnTimeout),
+		KeepAlive:             configSeconds(c, "KeepAlive", def.KeepAlive),
+		DialTimeout:           configSeconds(c, "DialTimeout", def.DialTimeout),
+		TLSHandshakeTimeout:   configSeconds(c, "TLSHandshakeTimeout", def.TLSHandshakeTimeout),
+		ResponseHeaderTimeout: configSeconds(c, "ResponseHeaderTimeout", def.ResponseHeaderTimeout),
+		RequestTimeout:        configSeconds(c, "RequestTimeout", def.RequestTimeout),
+	}
+	cfg.Transport = transport
+
+	//请求日志
+	debugLog := NewDebugLogConfig()
+	//默认的脱敏字段总是保留，配置的字段追加在后面
+	for _, field := range c.Strings("DebugRedactFields") {
+		if field = strings.TrimSpace(field); field != "" {
+			debugLog.RedactFields = append(debugLog.RedactFields, field)
+		}
+	}
+	debugLog.SampleRate = c.DefaultFloat("DebugSampleRate", debugLog.SampleRate)
+	cfg.APIDebug = c.DefaultBool("APIDebug", false)
+	cfg.DebugLog = debugLog
+
+	//新区块推送
+	cfg.BlockNotifyURL = c.String("BlockNotifyURL")
+	cfg.BlockNotifyReconnect = configSeconds(c, "BlockNotifyReconnect", defaultBlockNotifyReconnect)
+	cfg.BlockNotifyIdleTimeout = configSeconds(c, "BlockNotifyIdleTimeout", defaultBlockNotifyIdleTimeout)
+
+	//多地址出账
+	cfg.FundingMode = c.DefaultString("FundingMode", FundingModeSingle)
+	switch cfg.FundingMode {
+	case FundingModeSingle, FundingModeConsolidate, FundingModeSplit:
+	default:
+		return nil, nil, fmt.Errorf("invalid FundingMode: %s", cfg.FundingMode)
+	}
+
+	//出账地址选择
+	senderSpec := c.DefaultString("SenderStrategy", SenderStrategyFirst)
+	senderStrategy, err := NewSenderStrategy(senderSpec)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.SenderStrategy = senderStrategy
+	cfg.senderStrategySpecs[""] = strings.ToLower(strings.TrimSpace(senderSpec))
+	for _, s := range c.Strings("AccountSenderStrategies") {
+		accountID, strategy, err := ParseAccountSenderStrategy(s)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.AccountSenderStrategies[accountID] = strategy
+		cfg.senderStrategySpecs[accountID] = strings.ToLower(strings.TrimSpace(s))
+	}
+	cfg.BalanceWorkers = c.DefaultInt("BalanceWorkers", defaultBalanceWorkers)
+	cfg.MemoMaxLength = c.DefaultInt("MemoMaxLength", defaultMemoMaxLength)
+	cfg.SignNotes = c.DefaultBool("SignNotes", false)
+
+	//备注充值
+	cfg.SharedDepositAddresses = make(map[string]bool)
+	for _, address := range c.Strings("SharedDepositAddresses") {
+		cfg.SharedDepositAddresses[address] = true
+	}
+
+	//汇总手续费补充
+	cfg.FeesSupportPendingTimeout = configSeconds(c, "FeesSupportPendingTimeout", defaultFeesSupportPendingTimeout)
+
+	//扫描交易签名校验
+	cfg.TxVerifyMode = c.DefaultString("TxVerifyMode", TxVerifyOff)
+	switch cfg.TxVerifyMode {
+	case TxVerifyOff, TxVerifyFlag, TxVerifyReject:
+	default:
+		return nil, nil, fmt.Errorf("invalid TxVerifyMode: %s", cfg.TxVerifyMode)
+	}
+	cfg.TxVerifyNonceWindow = uint64(c.DefaultInt("TxVerifyNonceWindow", defaultTxVerifyNonceWindow))
+
+	//区块扫描
+	cfg.ScanWorkers = c.DefaultInt("ScanWorkers", maxExtractingSize)
+	if cfg.ScanWorkers <= 0 {
+		return nil, nil, fmt.Errorf("invalid ScanWorkers: %d", cfg.ScanWorkers)
+	}
+	if cfg.RescanLastBlockCount, err = configUint(c, "RescanLastBlockCount", defaultRescanLastBlockCount); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ScanStartHeight, err = configUint(c, "ScanStartHeight", 0); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ScanConfirmations, err = configUint(c, "ScanConfirmations", 0); err != nil {
+		return nil, nil, err
+	}
+	if cfg.MaxBlocksPerScan, err = configUint(c, "MaxBlocksPerScan", 0); err != nil {
+		return nil, nil, err
+	}
+	cfg.ScanPeriod = configSeconds(c, "ScanPeriod", defaultScanPeriod)
+	if cfg.ScanPeriod <= 0 {
+		return nil, nil, fmt.Errorf("invalid ScanPeriod: %v", cfg.ScanPeriod)
+	}
+
+	//地址登记队列
+	cfg.DataDir = c.DefaultString("DataDir", defaultDataDir)
+	cfg.InformInterval = configSeconds(c, "InformInterval", defaultInformInterval)
+	cfg.InformBatchSize = c.DefaultInt("InformBatchSize", defaultInformBatchSize)
+
+	client, err := NewClientWithTransport(cfg.ServerAPI, cfg.APIDebug, cfg.Transport)
+	if err != nil {
+		return nil, nil, err
+	}
+	client.SetDebugLog(cfg.DebugLog)
+	client.SetAuth(cfg.Auth)
+	client.SetCircuitBreaker(cfg.CircuitFailureThreshold, cfg.CircuitOpenTimeout)
+	client.MaxRetries = cfg.RateLimitRetries
+	client.SetRateLimit("", cfg.RateLimit)
+	for prefix, limit := range cfg.EndpointLimits {
+		client.SetRateLimit(prefix, limit)
+	}
+	if err := wm.checkNetwork(cfg, client); err != nil {
+		return nil, nil, err
+	}
+	return cfg, client, nil
+}
+
+//configSeconds 读取以秒为单位的时长配置
+func configSeconds(c config.Configer, key string, def time.Duration) time.Duration {
+	return time.Duration(c.DefaultInt(key, int(def/time.Second))) * time.Second
+}
+
+//configUint 读取非负整数配置
+func configUint(c config.Configer, key string, def uint64) (uint64, error) {
+	v := c.DefaultInt64(key, int64(def))
+	if v < 0 {
+		return 0, fmt.Errorf("invalid %s: %d", key, v)
+	}
+	return uint64(v), nil
+}
+
+//InitAssetsConfig 初始化默认配置，生成带注释的配置模板
 func (wm *WalletManager) InitAssetsConfig() (config.Configer, error) {
-	return config.NewConfigData("ini", []byte(""))
+	return config.NewConfigData("ini", []byte(ConfigTemplate()))
 }
 
 //GetAssetsLogger 获取资产账户日志工具